@@ -25,6 +25,7 @@ import (
 	"github.com/vultisig/verifier/vault"
 	"github.com/vultisig/verifier/vault_config"
 
+	"github.com/vultisig/feeplugin/internal/envconfig"
 	"github.com/vultisig/feeplugin/internal/fee"
 	"github.com/vultisig/feeplugin/internal/logging"
 	"github.com/vultisig/feeplugin/internal/metrics"
@@ -103,6 +104,8 @@ func main() {
 		logger.Fatalf("failed to initialize policy service: %v", err)
 	}
 
+	feeConfig := &cfg.FeeConfig
+
 	// Add metrics middleware to default middlewares
 	middlewares := append(server.DefaultMiddlewares(logger), metrics.HTTPMiddleware())
 
@@ -113,7 +116,7 @@ func main() {
 		vaultStorage,
 		asynqClient,
 		asynqInspector,
-		fee.NewSpec(),
+		fee.NewSpec(feeConfig.EnabledChains()),
 		middlewares,
 		smetrics.NewNilPluginServerMetrics(),
 		logger,
@@ -145,6 +148,7 @@ type FeeServerConfig struct {
 	BlockStorage   vault_config.BlockStorage `mapstructure:"block_storage" json:"block_storage,omitempty"`
 	Metrics        metrics.Config            `mapstructure:"metrics" json:"metrics,omitempty"`
 	Verifier       config.Verifier           `mapstructure:"verifier" json:"verifier,omitempty"`
+	FeeConfig      fee.FeeConfig             `mapstructure:"fee_config" json:"fee_config,omitempty"`
 }
 
 func GetConfigure() (*FeeServerConfig, error) {
@@ -160,7 +164,7 @@ func ReadConfig(configName string) (*FeeServerConfig, error) {
 	if configName == "" {
 		configName = "config"
 	}
-	addKeysToViper(viper.GetViper(), reflect.TypeOf(FeeServerConfig{}))
+	envconfig.AddKeysToViper(viper.GetViper(), reflect.TypeOf(FeeServerConfig{}))
 	viper.SetConfigName(configName)
 	viper.AddConfigPath(".")
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
@@ -175,57 +179,11 @@ func ReadConfig(configName string) (*FeeServerConfig, error) {
 		}
 		// This is required for ENV configs
 	}
-	var cfg FeeServerConfig
+	// fee_config is decoded onto the defaults, a setting set to 0 or false in the config turns it off
+	cfg := FeeServerConfig{FeeConfig: *fee.DefaultFeeConfig()}
 	err := viper.Unmarshal(&cfg)
 	if err != nil {
 		return nil, fmt.Errorf("unable to decode into struct, %w", err)
 	}
 	return &cfg, nil
 }
-
-func addKeysToViper(v *viper.Viper, t reflect.Type) {
-	keys := getAllKeys(t)
-	for _, key := range keys {
-		v.SetDefault(key, "")
-	}
-}
-
-func getAllKeys(t reflect.Type) []string {
-	var result []string
-
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-
-		// Try mapstructure tag first
-		tagName := f.Tag.Get("mapstructure")
-		if tagName == "" || tagName == "-" {
-			// Fallback to JSON tag
-			jsonTag := f.Tag.Get("json")
-			if jsonTag != "" && jsonTag != "-" {
-				// Handle comma-separated options (e.g., "field_name,omitempty")
-				tagName = strings.Split(jsonTag, ",")[0]
-			}
-		} else {
-			// Handle comma-separated options in mapstructure tag
-			tagName = strings.Split(tagName, ",")[0]
-		}
-
-		// Final fallback to field name if no valid tags found
-		if tagName == "" || tagName == "-" {
-			tagName = f.Name
-		}
-
-		n := strings.ToUpper(tagName)
-
-		if reflect.Struct == f.Type.Kind() {
-			subKeys := getAllKeys(f.Type)
-			for _, k := range subKeys {
-				result = append(result, n+"."+k)
-			}
-		} else {
-			result = append(result, n)
-		}
-	}
-
-	return result
-}
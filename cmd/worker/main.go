@@ -2,13 +2,12 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"reflect"
 	"strings"
-	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/hibiken/asynq"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sirupsen/logrus"
@@ -22,8 +21,11 @@ import (
 	"github.com/vultisig/verifier/plugin/tx_indexer/pkg/storage"
 	"github.com/vultisig/verifier/vault"
 	"github.com/vultisig/verifier/vault_config"
+	"github.com/vultisig/vultisig-go/common"
 	"github.com/vultisig/vultisig-go/relay"
 
+	"github.com/vultisig/feeplugin/internal/envconfig"
+	"github.com/vultisig/feeplugin/internal/evmrpc"
 	"github.com/vultisig/feeplugin/internal/fee"
 	"github.com/vultisig/feeplugin/internal/health"
 	"github.com/vultisig/feeplugin/internal/logging"
@@ -98,11 +100,6 @@ func main() {
 		logger.Fatalf("failed to get supported chains: %v", err)
 	}
 
-	rpcClient, err := ethclient.Dial(cfg.FeeConfig.EthProvider)
-	if err != nil {
-		panic(fmt.Errorf("failed to create eth client: %w", err))
-	}
-
 	txIndexerService := tx_indexer.NewService(
 		logger,
 		txStorage,
@@ -120,24 +117,49 @@ func main() {
 		logger.Fatalf("failed to create vault service: %v", err)
 	}
 
-	feeConfig := fee.DefaultFeeConfig()
+	feeConfig := &cfg.FeeConfig
 	feeConfig.VerifierToken = cfg.Verifier.Token
-	feeConfig.EthProvider = cfg.FeeConfig.EthProvider
-	feeConfig.TreasuryAddress = cfg.FeeConfig.TreasuryAddress
-	feeConfig.UsdcAddress = cfg.FeeConfig.UsdcAddress
 
 	err = feeConfig.Validate()
 	if err != nil {
 		logger.Fatalf("invalid fee config: %v", err)
 	}
 
+	// an unreachable provider does not fail the start, requests fail over to the next one
+	rpcs := make(map[common.Chain]*evmrpc.Client)
+	for _, chain := range feeConfig.EnabledChains() {
+		if !chain.IsEvm() {
+			continue
+		}
+		chainID, err := chain.EvmID()
+		if err != nil {
+			logger.Fatalf("failed to get %s EVM ID: %v", chain.String(), err)
+		}
+		chainConfig, _ := feeConfig.ChainConfig(chain)
+		rpcs[chain], err = evmrpc.Dial(
+			ctx,
+			chain.String(),
+			chainID,
+			chainConfig.Providers(),
+			evmrpc.Config{
+				HealthCheckInterval: feeConfig.Rpc.HealthCheckInterval,
+				MaxBlockLag:         feeConfig.Rpc.MaxBlockLag,
+			},
+			logger.WithField("pkg", "evmrpc"),
+			metrics.NewRpcMetrics(),
+		)
+		if err != nil {
+			logger.Fatalf("failed to create %s client: %v", chain.String(), err)
+		}
+	}
+
 	feePlugin, err := fee.NewFeePlugin(
 		feeConfig,
 		logger,
 		vaultService,
 		vaultStorage,
 		cfg.VaultServiceConfig.EncryptionSecret,
-		rpcClient,
+		rpcs,
 		keysign.NewSigner(
 			logger.WithField("pkg", "keysign.Signer").Logger,
 			relay.NewRelayClient(cfg.VaultServiceConfig.Relay.Server),
@@ -151,14 +173,23 @@ func main() {
 			},
 		),
 		txIndexerService,
+		client,
 		db,
 		cfg.Verifier.URL,
-		cfg.ProcessingInterval,
 	)
 	if err != nil {
 		logger.Fatalf("failed to initialize feePlugin: %v", err)
 	}
 
+	if feeConfig.DryRun.Enabled {
+		err = writeDryRunReport(ctx, feePlugin, feeConfig.DryRun.ReportPath)
+		if err != nil {
+			logger.Fatalf("dry run failed: %v", err)
+		}
+		logger.Info("dry run completed")
+		return
+	}
+
 	healthServer := health.New(cfg.HealthPort)
 	go func() {
 		err = healthServer.Start(ctx, logger)
@@ -167,17 +198,70 @@ func main() {
 		}
 	}()
 
-	go feePlugin.Run(ctx)
+	scheduler := asynq.NewScheduler(redisConnOpt, &asynq.SchedulerOpts{
+		Logger: logger,
+	})
+	for _, job := range []struct {
+		cronexpr string
+		taskType string
+	}{
+		{feeConfig.Jobs.Load.Cronexpr, fee.TypeFeeLoad},
+		{feeConfig.Jobs.Transact.Cronexpr, fee.TypeFeeTransact},
+		{feeConfig.Jobs.Post.Cronexpr, fee.TypeFeePostTx},
+	} {
+		_, err = scheduler.Register(
+			job.cronexpr,
+			asynq.NewTask(job.taskType, nil),
+			asynq.Queue(tasks.QUEUE_NAME),
+			asynq.MaxRetry(3),
+		)
+		if err != nil {
+			logger.Fatalf("failed to register %s task: %v", job.taskType, err)
+		}
+	}
+	err = scheduler.Start()
+	if err != nil {
+		logger.Fatalf("failed to start scheduler: %v", err)
+	}
+	defer scheduler.Shutdown()
 
 	mux := asynq.NewServeMux()
 	mux.HandleFunc(tasks.TypeKeySignDKLS, vaultService.HandleKeySignDKLS)
 	mux.HandleFunc(tasks.TypeReshareDKLS, feePlugin.HandleReshareDKLS)
+	mux.HandleFunc(fee.TypeFeeLoad, feePlugin.HandleLoad)
+	mux.HandleFunc(fee.TypeFeeTransact, feePlugin.HandleTransact)
+	mux.HandleFunc(fee.TypeFeePostTx, feePlugin.HandlePostTx)
 	err = consumer.Run(mux)
 	if err != nil {
 		logger.Fatalf("failed to run consumer: %v", err)
 	}
 }
 
+// writeDryRunReport plans the collection of every vault and writes the report to path, stdout if empty
+func writeDryRunReport(ctx context.Context, feePlugin *fee.FeePlugin, path string) error {
+	report, err := feePlugin.DryRun(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if path != "" {
+		out, err = os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create report file: %w", err)
+		}
+		defer out.Close()
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(report)
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
 type FeeWorkerConfig struct {
 	LogFormat          logging.LogFormat         `mapstructure:"log_format" json:"log_format,omitempty" default:"text"`
 	Redis              config.Redis              `mapstructure:"redis" json:"redis,omitempty"`
@@ -187,7 +271,6 @@ type FeeWorkerConfig struct {
 	BaseConfigPath     string                    `mapstructure:"base_config_path" json:"base_config_path,omitempty"`
 	Database           config.Database           `mapstructure:"database" json:"database,omitempty"`
 	FeeConfig          fee.FeeConfig             `mapstructure:"fee_config" json:"fee_config,omitempty"`
-	ProcessingInterval time.Duration             `mapstructure:"processing_interval" json:"processing_interval,omitempty"`
 	HealthPort         int                       `mapstructure:"health_port" json:"health_port,omitempty"`
 	Metrics            metrics.Config            `mapstructure:"metrics" json:"metrics,omitempty"`
 }
@@ -204,7 +287,7 @@ func ReadConfig(configName string) (*FeeWorkerConfig, error) {
 	if configName == "" {
 		configName = "config"
 	}
-	addKeysToViper(viper.GetViper(), reflect.TypeOf(FeeWorkerConfig{}))
+	envconfig.AddKeysToViper(viper.GetViper(), reflect.TypeOf(FeeWorkerConfig{}))
 	viper.SetConfigName(configName)
 	viper.AddConfigPath(".")
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
@@ -218,57 +301,11 @@ func ReadConfig(configName string) (*FeeWorkerConfig, error) {
 		}
 		// This is required for ENV configs
 	}
-	var cfg FeeWorkerConfig
+	// fee_config is decoded onto the defaults, a setting set to 0 or false in the config turns it off
+	cfg := FeeWorkerConfig{FeeConfig: *fee.DefaultFeeConfig()}
 	err := viper.Unmarshal(&cfg)
 	if err != nil {
 		return nil, fmt.Errorf("unable to decode into struct, %w", err)
 	}
 	return &cfg, nil
 }
-
-func addKeysToViper(v *viper.Viper, t reflect.Type) {
-	keys := getAllKeys(t)
-	for _, key := range keys {
-		v.SetDefault(key, "")
-	}
-}
-
-func getAllKeys(t reflect.Type) []string {
-	var result []string
-
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-
-		// Try mapstructure tag first
-		tagName := f.Tag.Get("mapstructure")
-		if tagName == "" || tagName == "-" {
-			// Fallback to JSON tag
-			jsonTag := f.Tag.Get("json")
-			if jsonTag != "" && jsonTag != "-" {
-				// Handle comma-separated options (e.g., "field_name,omitempty")
-				tagName = strings.Split(jsonTag, ",")[0]
-			}
-		} else {
-			// Handle comma-separated options in mapstructure tag
-			tagName = strings.Split(tagName, ",")[0]
-		}
-
-		// Final fallback to field name if no valid tags found
-		if tagName == "" || tagName == "-" {
-			tagName = f.Name
-		}
-
-		n := strings.ToUpper(tagName)
-
-		if reflect.Struct == f.Type.Kind() {
-			subKeys := getAllKeys(f.Type)
-			for _, k := range subKeys {
-				result = append(result, n+"."+k)
-			}
-		} else {
-			result = append(result, n)
-		}
-	}
-
-	return result
-}
@@ -0,0 +1,58 @@
+package envconfig
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// AddKeysToViper binds every key of the config struct to its environment variable, so that a setting can
+// be given by env alone. Keys are bound rather than defaulted to "", which does not decode into durations
+// or maps.
+func AddKeysToViper(v *viper.Viper, t reflect.Type) {
+	keys := getAllKeys(t)
+	for _, key := range keys {
+		_ = v.BindEnv(key)
+	}
+}
+
+func getAllKeys(t reflect.Type) []string {
+	var result []string
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		// Try mapstructure tag first
+		tagName := f.Tag.Get("mapstructure")
+		if tagName == "" || tagName == "-" {
+			// Fallback to JSON tag
+			jsonTag := f.Tag.Get("json")
+			if jsonTag != "" && jsonTag != "-" {
+				// Handle comma-separated options (e.g., "field_name,omitempty")
+				tagName = strings.Split(jsonTag, ",")[0]
+			}
+		} else {
+			// Handle comma-separated options in mapstructure tag
+			tagName = strings.Split(tagName, ",")[0]
+		}
+
+		// Final fallback to field name if no valid tags found
+		if tagName == "" || tagName == "-" {
+			tagName = f.Name
+		}
+
+		n := strings.ToUpper(tagName)
+
+		if reflect.Struct == f.Type.Kind() {
+			subKeys := getAllKeys(f.Type)
+			for _, k := range subKeys {
+				result = append(result, n+"."+k)
+			}
+		} else {
+			result = append(result, n)
+		}
+	}
+
+	return result
+}
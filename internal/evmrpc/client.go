@@ -0,0 +1,421 @@
+package evmrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/sirupsen/logrus"
+
+	"github.com/vultisig/feeplugin/internal/metrics"
+)
+
+// healthCheckTimeout bounds the calls of a health check, an endpoint slower than that is unhealthy
+const healthCheckTimeout = 10 * time.Second
+
+// Config of the health checks of a Client
+type Config struct {
+	HealthCheckInterval time.Duration // how often the endpoints are checked
+	MaxBlockLag         uint64        // how many blocks an endpoint can be behind the most advanced one
+}
+
+// Client is an Ethereum JSON-RPC client over an ordered list of endpoints. A request goes to the first
+// healthy endpoint and moves on to the next one when the endpoint can't be reached. An error the node
+// answered with, a revert or a rejected tx, is returned as is. Endpoints which fail a request, lag behind
+// the others or serve another chain are out of rotation until a health check finds them healthy again,
+// they are only tried once every healthy endpoint failed.
+type Client struct {
+	chain     string // name of the chain, for logs and metrics
+	chainID   *big.Int
+	config    Config
+	endpoints []*endpoint
+	logger    logrus.FieldLogger
+	metrics   *metrics.RpcMetrics
+}
+
+type endpoint struct {
+	name    string // host of the url, the rest of it may hold an api key
+	raw     *gethrpc.Client
+	eth     *ethclient.Client
+	healthy atomic.Bool
+}
+
+// Dial connects to the endpoints of the chain in order, checks their health once and keeps checking it
+// until ctx is done
+func Dial(
+	ctx context.Context,
+	chain string,
+	chainID *big.Int,
+	urls []string,
+	config Config,
+	logger logrus.FieldLogger,
+	rpcMetrics *metrics.RpcMetrics,
+) (*Client, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no endpoint for %s", chain)
+	}
+
+	c := &Client{
+		chain:   chain,
+		chainID: chainID,
+		config:  config,
+		logger:  logger.WithField("chain", chain),
+		metrics: rpcMetrics,
+	}
+	for _, u := range urls {
+		raw, err := gethrpc.DialContext(ctx, u)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial %s: %w", Name(u), err)
+		}
+		e := &endpoint{
+			name: Name(u),
+			raw:  raw,
+			eth:  ethclient.NewClient(raw),
+		}
+		// in rotation until the first health check says otherwise
+		e.healthy.Store(true)
+		c.endpoints = append(c.endpoints, e)
+	}
+
+	c.checkHealth(ctx)
+	if config.HealthCheckInterval > 0 {
+		go c.healthLoop(ctx)
+	}
+	return c, nil
+}
+
+// Name is what is logged and recorded of an endpoint, its host
+func Name(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "unknown"
+	}
+	return u.Host
+}
+
+func (c *Client) healthLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.config.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkHealth(ctx)
+		}
+	}
+}
+
+// checkHealth queries the chain id and latest block of every endpoint at once. An endpoint is healthy when
+// it answers, serves the chain and is at most Config.MaxBlockLag blocks behind the most advanced endpoint.
+func (c *Client) checkHealth(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	type status struct {
+		block uint64
+		err   error
+	}
+	statuses := make([]status, len(c.endpoints))
+	var wg sync.WaitGroup
+	for i, e := range c.endpoints {
+		wg.Add(1)
+		go func(i int, e *endpoint) {
+			defer wg.Done()
+			chainID, err := e.eth.ChainID(ctx)
+			if err != nil {
+				statuses[i].err = fmt.Errorf("failed to get chain id: %w", err)
+				return
+			}
+			if chainID.Cmp(c.chainID) != 0 {
+				statuses[i].err = fmt.Errorf("chain id %s, expected %s", chainID.String(), c.chainID.String())
+				return
+			}
+			statuses[i].block, statuses[i].err = e.eth.BlockNumber(ctx)
+		}(i, e)
+	}
+	wg.Wait()
+
+	var highest uint64
+	for _, s := range statuses {
+		if s.err == nil && s.block > highest {
+			highest = s.block
+		}
+	}
+
+	for i, e := range c.endpoints {
+		s := statuses[i]
+		err := s.err
+		lag := highest - s.block
+		if err == nil && lag > c.config.MaxBlockLag {
+			err = fmt.Errorf("%d blocks behind", lag)
+		}
+		if err == nil && c.metrics != nil {
+			c.metrics.RecordBlockLag(c.chain, e.name, lag)
+		}
+		c.setHealthy(e, err)
+	}
+}
+
+// setHealthy takes the endpoint out of rotation when err is set, else puts it back
+func (c *Client) setHealthy(e *endpoint, err error) {
+	healthy := err == nil
+	if c.metrics != nil {
+		c.metrics.RecordEndpointHealth(c.chain, e.name, healthy)
+	}
+	if e.healthy.Swap(healthy) == healthy {
+		return
+	}
+
+	logger := c.logger.WithField("endpoint", e.name)
+	if healthy {
+		logger.Info("rpc endpoint back in rotation")
+		return
+	}
+	logger.WithError(err).Warn("rpc endpoint out of rotation")
+}
+
+// candidates returns the endpoints in the order a request tries them, the healthy ones first
+func (c *Client) candidates() []*endpoint {
+	var healthy, unhealthy []*endpoint
+	for _, e := range c.endpoints {
+		if e.healthy.Load() {
+			healthy = append(healthy, e)
+		} else {
+			unhealthy = append(unhealthy, e)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// rpcLimitExceededCode is the JSON-RPC error code of a node refusing requests over its rate limit
+const rpcLimitExceededCode = -32005
+
+// unreachable reports whether err means the endpoint could not serve the request, rather than the node
+// answering it with an error or the caller giving up. A node rate limiting the plugin did not serve it.
+func unreachable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || errors.Is(err, ethereum.NotFound) {
+		return false
+	}
+	var rpcErr gethrpc.Error
+	if !errors.As(err, &rpcErr) {
+		return true
+	}
+	message := strings.ToLower(rpcErr.Error())
+	return rpcErr.ErrorCode() == rpcLimitExceededCode ||
+		strings.Contains(message, "rate limit") ||
+		strings.Contains(message, "too many requests")
+}
+
+// call runs the request against the endpoints until one serves it, it returns the endpoint along with
+// the result
+func call[T any](ctx context.Context, c *Client, request func(e *endpoint) (T, error)) (T, *endpoint, error) {
+	var (
+		zero T
+		errs []error
+	)
+	for _, e := range c.candidates() {
+		res, err := request(e)
+		if !unreachable(ctx, err) {
+			if c.metrics != nil {
+				status := metrics.RpcStatusSuccess
+				if err != nil {
+					status = metrics.RpcStatusRejected
+				}
+				c.metrics.RecordRequest(c.chain, e.name, status)
+			}
+			return res, e, err
+		}
+
+		if c.metrics != nil {
+			c.metrics.RecordRequest(c.chain, e.name, metrics.RpcStatusUnavailable)
+		}
+		c.setHealthy(e, err)
+		errs = append(errs, fmt.Errorf("%s: %w", e.name, err))
+	}
+	return zero, nil, fmt.Errorf("no %s rpc endpoint available: %w", c.chain, errors.Join(errs...))
+}
+
+// callErr is call for requests without a result
+func callErr(ctx context.Context, c *Client, request func(e *endpoint) error) error {
+	_, _, err := call(ctx, c, func(e *endpoint) (struct{}, error) {
+		return struct{}{}, request(e)
+	})
+	return err
+}
+
+// SendRawTransaction sends the signed tx, it returns the endpoint which accepted it. A node may have taken
+// the tx before the connection to it failed, so an error a later endpoint answered with, such as already
+// known, does not mean the tx was refused and is not returned as an answer of the node.
+func (c *Client) SendRawTransaction(ctx context.Context, signed []byte) (string, error) {
+	tried := 0
+	_, e, err := call(ctx, c, func(e *endpoint) (struct{}, error) {
+		tried++
+		return struct{}{}, e.raw.CallContext(ctx, nil, "eth_sendRawTransaction", hexutil.Encode(signed))
+	})
+	if err != nil && tried > 1 {
+		return "", fmt.Errorf("tx refused after a failed send, it may still be pending: %s", err.Error())
+	}
+	if err != nil {
+		return "", err
+	}
+	return e.name, nil
+}
+
+// CallContext runs a raw JSON-RPC request
+func (c *Client) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	return callErr(ctx, c, func(e *endpoint) error {
+		return e.raw.CallContext(ctx, result, method, args...)
+	})
+}
+
+func (c *Client) ChainID(ctx context.Context) (*big.Int, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) (*big.Int, error) {
+		return e.eth.ChainID(ctx)
+	})
+	return res, err
+}
+
+func (c *Client) BlockNumber(ctx context.Context) (uint64, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) (uint64, error) {
+		return e.eth.BlockNumber(ctx)
+	})
+	return res, err
+}
+
+func (c *Client) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) (*types.Header, error) {
+		return e.eth.HeaderByNumber(ctx, number)
+	})
+	return res, err
+}
+
+func (c *Client) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) (*big.Int, error) {
+		return e.eth.BalanceAt(ctx, account, blockNumber)
+	})
+	return res, err
+}
+
+func (c *Client) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) ([]byte, error) {
+		return e.eth.CodeAt(ctx, account, blockNumber)
+	})
+	return res, err
+}
+
+func (c *Client) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) ([]byte, error) {
+		return e.eth.PendingCodeAt(ctx, account)
+	})
+	return res, err
+}
+
+func (c *Client) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) (uint64, error) {
+		return e.eth.PendingNonceAt(ctx, account)
+	})
+	return res, err
+}
+
+func (c *Client) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) ([]byte, error) {
+		return e.eth.CallContract(ctx, msg, blockNumber)
+	})
+	return res, err
+}
+
+func (c *Client) PendingCallContract(ctx context.Context, msg ethereum.CallMsg) ([]byte, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) ([]byte, error) {
+		return e.eth.PendingCallContract(ctx, msg)
+	})
+	return res, err
+}
+
+func (c *Client) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) (uint64, error) {
+		return e.eth.EstimateGas(ctx, msg)
+	})
+	return res, err
+}
+
+func (c *Client) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) (*big.Int, error) {
+		return e.eth.SuggestGasPrice(ctx)
+	})
+	return res, err
+}
+
+func (c *Client) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) (*big.Int, error) {
+		return e.eth.SuggestGasTipCap(ctx)
+	})
+	return res, err
+}
+
+func (c *Client) FeeHistory(
+	ctx context.Context,
+	blockCount uint64,
+	lastBlock *big.Int,
+	rewardPercentiles []float64,
+) (*ethereum.FeeHistory, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) (*ethereum.FeeHistory, error) {
+		return e.eth.FeeHistory(ctx, blockCount, lastBlock, rewardPercentiles)
+	})
+	return res, err
+}
+
+// SendTransaction sends the signed tx, see SendRawTransaction
+func (c *Client) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	signed, err := tx.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to encode tx: %w", err)
+	}
+	_, err = c.SendRawTransaction(ctx, signed)
+	return err
+}
+
+func (c *Client) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) (*types.Receipt, error) {
+		return e.eth.TransactionReceipt(ctx, hash)
+	})
+	return res, err
+}
+
+func (c *Client) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	type result struct {
+		tx        *types.Transaction
+		isPending bool
+	}
+	res, _, err := call(ctx, c, func(e *endpoint) (result, error) {
+		tx, isPending, err := e.eth.TransactionByHash(ctx, hash)
+		return result{tx: tx, isPending: isPending}, err
+	})
+	return res.tx, res.isPending, err
+}
+
+func (c *Client) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) ([]types.Log, error) {
+		return e.eth.FilterLogs(ctx, q)
+	})
+	return res, err
+}
+
+func (c *Client) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	res, _, err := call(ctx, c, func(e *endpoint) (ethereum.Subscription, error) {
+		return e.eth.SubscribeFilterLogs(ctx, q, ch)
+	})
+	return res, err
+}
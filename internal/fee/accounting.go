@@ -0,0 +1,42 @@
+package fee
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	vtypes "github.com/vultisig/verifier/types"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+var errUnknownTxType = errors.New("unknown fee tx type")
+
+// FeeBreakdown is the debt of a batch of fees, in USDC base units
+type FeeBreakdown struct {
+	Debits  *big.Int
+	Credits *big.Int
+	Net     *big.Int // debits minus credits, negative when the credits exceed the debits
+}
+
+// AggregateFees sums the debits and credits of the fees. A fee of a tx type it does not know fails the
+// whole batch rather than being left out of the debt.
+func AggregateFees(fees []feetypes.Fee) (*FeeBreakdown, error) {
+	b := &FeeBreakdown{
+		Debits:  new(big.Int),
+		Credits: new(big.Int),
+	}
+	for _, fee := range fees {
+		amount := new(big.Int).SetUint64(fee.Amount)
+		switch vtypes.TxType(fee.TxType) {
+		case vtypes.TxTypeDebit:
+			b.Debits.Add(b.Debits, amount)
+		case vtypes.TxTypeCredit:
+			b.Credits.Add(b.Credits, amount)
+		default:
+			return nil, fmt.Errorf("fee %d: %w %q", fee.FeeID, errUnknownTxType, fee.TxType)
+		}
+	}
+	b.Net = new(big.Int).Sub(b.Debits, b.Credits)
+	return b, nil
+}
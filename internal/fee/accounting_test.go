@@ -0,0 +1,144 @@
+package fee
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+
+	vtypes "github.com/vultisig/verifier/types"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+func debit(id, amount uint64) feetypes.Fee {
+	return feetypes.Fee{FeeID: id, TxType: string(vtypes.TxTypeDebit), Amount: amount}
+}
+
+func credit(id, amount uint64) feetypes.Fee {
+	return feetypes.Fee{FeeID: id, TxType: string(vtypes.TxTypeCredit), Amount: amount}
+}
+
+func bigInt(t *testing.T, s string) *big.Int {
+	t.Helper()
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		t.Fatalf("invalid big int %q", s)
+	}
+	return n
+}
+
+func TestAggregateFees(t *testing.T) {
+	tests := []struct {
+		name    string
+		fees    []feetypes.Fee
+		debits  string
+		credits string
+		net     string
+	}{
+		{
+			name:    "no fees",
+			debits:  "0",
+			credits: "0",
+			net:     "0",
+		},
+		{
+			name:    "debits only",
+			fees:    []feetypes.Fee{debit(1, 100), debit(2, 250)},
+			debits:  "350",
+			credits: "0",
+			net:     "350",
+		},
+		{
+			name:    "debits above uint64",
+			fees:    []feetypes.Fee{debit(1, math.MaxUint64), debit(2, math.MaxUint64), debit(3, 2)},
+			debits:  "36893488147419103232",
+			credits: "0",
+			net:     "36893488147419103232",
+		},
+		{
+			name:    "credits above uint64",
+			fees:    []feetypes.Fee{credit(1, math.MaxUint64), credit(2, 1), debit(3, 5)},
+			debits:  "5",
+			credits: "18446744073709551616",
+			net:     "-18446744073709551611",
+		},
+		{
+			name:    "credits equal to debits",
+			fees:    []feetypes.Fee{debit(1, 300), credit(2, 300)},
+			debits:  "300",
+			credits: "300",
+			net:     "0",
+		},
+		{
+			name:    "credits above debits",
+			fees:    []feetypes.Fee{debit(1, 100), credit(2, 400)},
+			debits:  "100",
+			credits: "400",
+			net:     "-300",
+		},
+		{
+			name:    "credit before debits",
+			fees:    []feetypes.Fee{credit(1, 50), debit(2, 100), debit(3, 20)},
+			debits:  "120",
+			credits: "50",
+			net:     "70",
+		},
+		{
+			name:    "interleaved debits and credits",
+			fees:    []feetypes.Fee{debit(1, 100), credit(2, 30), debit(3, 40), credit(4, 10), debit(5, 1)},
+			debits:  "141",
+			credits: "40",
+			net:     "101",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := AggregateFees(tt.fees)
+			if err != nil {
+				t.Fatalf("AggregateFees: %v", err)
+			}
+			if b.Debits.Cmp(bigInt(t, tt.debits)) != 0 {
+				t.Errorf("debits = %s, want %s", b.Debits, tt.debits)
+			}
+			if b.Credits.Cmp(bigInt(t, tt.credits)) != 0 {
+				t.Errorf("credits = %s, want %s", b.Credits, tt.credits)
+			}
+			if b.Net.Cmp(bigInt(t, tt.net)) != 0 {
+				t.Errorf("net = %s, want %s", b.Net, tt.net)
+			}
+		})
+	}
+}
+
+func TestAggregateFeesUnknownTxType(t *testing.T) {
+	tests := []struct {
+		name string
+		fees []feetypes.Fee
+	}{
+		{
+			name: "unknown type",
+			fees: []feetypes.Fee{debit(1, 100), {FeeID: 2, TxType: "refund", Amount: 10}},
+		},
+		{
+			name: "empty type",
+			fees: []feetypes.Fee{{FeeID: 1, Amount: 10}, credit(2, 5)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := AggregateFees(tt.fees)
+			if !errors.Is(err, errUnknownTxType) {
+				t.Fatalf("err = %v, want errUnknownTxType", err)
+			}
+			if b != nil {
+				t.Errorf("breakdown = %+v, want nil", b)
+			}
+			if !isPermanentFailure(err) {
+				t.Errorf("unknown tx type is not a permanent failure")
+			}
+		})
+	}
+}
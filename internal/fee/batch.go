@@ -0,0 +1,111 @@
+package fee
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+// feeBatchKey identifies a batch of fees whatever their order, the db allows a single unfinished run per key
+func feeBatchKey(feeIds []uint64) string {
+	sorted := slices.Clone(feeIds)
+	slices.Sort(sorted)
+
+	ids := make([]string, len(sorted))
+	for i, id := range sorted {
+		ids[i] = strconv.FormatUint(id, 10)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(ids, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// resumeFeeRun finishes the run a worker left unsent, it reports whether there was one to resume. The
+// vault is leased, so such a run is not in progress on another replica.
+//
+// A draft run never had its tx signed, it is failed and its fees are collected by a new run of the same
+// batch. A signed run may or may not have been broadcasted: the stored tx is broadcasted again, which is
+// harmless if it already was as it keeps its hash, and the run moves to sent for fees:post_tx to track.
+// A run whose tx the node rejects for good is failed unless the tx is already on chain, one which could
+// not be broadcasted stays signed and is resumed again with the next run of the vault.
+func (fp *FeePlugin) resumeFeeRun(ctx context.Context, publickey string) (bool, error) {
+	run, err := fp.db.GetUnsentFeeRun(ctx, publickey)
+	if err != nil {
+		return false, fmt.Errorf("failed to get unsent fee run: %w", err)
+	}
+	if run == nil {
+		return false, nil
+	}
+
+	logger := fp.logger.WithFields(logrus.Fields{
+		"fee_run_id": run.ID,
+		"pubkey":     publickey,
+		"chain":      run.Chain,
+	})
+	if run.BatchKey != nil {
+		logger = logger.WithField("batch_key", *run.BatchKey)
+	}
+
+	if run.Status == feetypes.FeeRunStateDraft {
+		logger.Warn("fee run interrupted before signing, releasing its fees")
+		err = fp.db.SetFeeRunFailed(ctx, run.ID, feetypes.TxErrorInterrupted, "interrupted before signing")
+		if err != nil {
+			return false, fmt.Errorf("failed to set fee run %s as failed: %w", run.ID, err)
+		}
+		return false, nil
+	}
+
+	c, err := fp.collectorByName(run.Chain)
+	if err != nil {
+		return false, err
+	}
+
+	attempts, err := fp.db.GetFeeRunAttempts(ctx, run.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get fee run attempts: %w", err)
+	}
+	if len(attempts) == 0 || attempts[0].SignedTx == "" {
+		return false, fmt.Errorf("signed fee run %s has no signed tx", run.ID)
+	}
+	attempt := attempts[0]
+
+	signed, err := base64.StdEncoding.DecodeString(attempt.SignedTx)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode b64 signed tx: %w", err)
+	}
+
+	logger = logger.WithField("hash", attempt.TxHash)
+	endpoint, err := c.Broadcast(ctx, signed)
+	if isTxError(err, feetypes.TxErrorBroadcastRejected) {
+		// the tx was mined before the worker stopped, e.g. its nonce is now too low
+		receipt, e := c.Receipt(ctx, attempt.TxHash)
+		if e != nil {
+			return false, fmt.Errorf("failed to get receipt of rejected fee tx: %w", e)
+		}
+		if receipt.Status == ReceiptStatusNotFound {
+			logger.WithError(err).Warn("signed fee tx rejected, failing the run")
+			fp.failFeeRun(ctx, run.ID, err)
+			return false, err
+		}
+		err = nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to broadcast signed fee tx again: %w", err)
+	}
+
+	err = fp.db.SetFeeRunSent(ctx, run.ID, attempt.TxHash, endpoint)
+	if err != nil {
+		return false, fmt.Errorf("failed to set fee run %s as sent: %w", run.ID, err)
+	}
+
+	logger.Info("signed fee run resumed")
+	return true, nil
+}
@@ -0,0 +1,96 @@
+package fee
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/vultisig/mobile-tss-lib/tss"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+// signTestRun leaves a signed run of the test vault behind, as a worker stopped before the broadcast would
+func signTestRun(t *testing.T, c *fakeCollector, db *fakeDB) *feetypes.FeeRun {
+	t.Helper()
+	ctx := context.Background()
+	created, err := db.CreateFeeRun(ctx, feetypes.FeeRun{PublicKey: testPublicKey, Chain: c.chain.String(), TotalAmount: 8e6})
+	if err != nil {
+		t.Fatalf("CreateFeeRun: %v", err)
+	}
+	run, err := db.run(created.ID)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	tx, err := c.BuildTransfer(ctx, testVaultAddress, testTreasuryAddress, testUsdcAddress, big.NewInt(8e6))
+	if err != nil {
+		t.Fatalf("BuildTransfer: %v", err)
+	}
+	signed, err := c.SignedTx(tx, map[string]tss.KeysignResponse{"msg": {R: "r", S: "s"}})
+	if err != nil {
+		t.Fatalf("SignedTx: %v", err)
+	}
+	hash, err := c.TxHash(signed)
+	if err != nil {
+		t.Fatalf("TxHash: %v", err)
+	}
+	err = db.SetFeeRunSigned(ctx, run.ID, feetypes.FeeRunAttempt{
+		Kind:       feetypes.FeeRunAttemptOriginal,
+		TxHash:     hash,
+		UnsignedTx: base64.StdEncoding.EncodeToString(tx),
+		SignedTx:   base64.StdEncoding.EncodeToString(signed),
+	})
+	if err != nil {
+		t.Fatalf("SetFeeRunSigned: %v", err)
+	}
+	return run
+}
+
+func TestResumeSignedFeeRun(t *testing.T) {
+	rejected := &feetypes.TransactionError{Code: feetypes.TxErrorBroadcastRejected, Message: "nonce too low"}
+
+	tests := []struct {
+		name    string
+		sendErr error
+		receipt *Receipt
+		want    feetypes.FeeRunState
+		wantErr bool
+	}{
+		{name: "broadcasted", want: feetypes.FeeRunStateSent},
+		{name: "node unreachable", sendErr: errors.New("connection refused"), want: feetypes.FeeRunStateSigned, wantErr: true},
+		{name: "rejected", sendErr: rejected, want: feetypes.FeeRunStateFailed, wantErr: true},
+		{
+			name:    "rejected once mined",
+			sendErr: rejected,
+			receipt: &Receipt{Status: ReceiptStatusSuccess, Confirmations: 1},
+			want:    feetypes.FeeRunStateSent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, db := newFakeCollector(), newFakeDB()
+			fp := newTestFeePlugin(t, c, db, newFakeVerifier(t))
+			run := signTestRun(t, c, db)
+			c.sendErr = tt.sendErr
+			if tt.receipt != nil {
+				c.receipts[*run.TxHash] = tt.receipt
+			}
+
+			resumed, err := fp.resumeFeeRun(context.Background(), testPublicKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resumeFeeRun err = %v, want an error %t", err, tt.wantErr)
+			}
+			if resumed != (err == nil) {
+				t.Errorf("resumed = %t with err %v", resumed, err)
+			}
+			assertStatus(t, run, tt.want)
+			if tt.want == feetypes.FeeRunStateFailed && (run.ErrorCode == nil || *run.ErrorCode != feetypes.TxErrorBroadcastRejected) {
+				t.Errorf("error code = %v, want %s", run.ErrorCode, feetypes.TxErrorBroadcastRejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package fee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vultisig/feeplugin/internal/evmrpc"
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+// BroadcastConfig picks how the signed fee txs of a chain reach the network
+type BroadcastConfig struct {
+	Strategy  feetypes.BroadcastStrategy `mapstructure:"strategy,omitempty"`  // single, fan_out or private_relay, single if not set.
+	Endpoints []string                   `mapstructure:"endpoints,omitempty"` // RPC endpoints a fan_out broadcast is sent to along with the provider.
+	Relay     string                     `mapstructure:"relay,omitempty"`     // The private relay of private_relay broadcasts, e.g. Flashbots Protect. A relayed tx is unknown to the provider until mined, jobs.post.drop_after has to exceed how long the relay holds it.
+}
+
+func (c BroadcastConfig) validate() error {
+	switch c.Strategy {
+	case feetypes.BroadcastStrategySingle:
+	case feetypes.BroadcastStrategyFanOut:
+		if len(c.Endpoints) == 0 {
+			return errors.New("endpoints is required with the fan_out strategy")
+		}
+	case feetypes.BroadcastStrategyPrivateRelay:
+		if c.Relay == "" {
+			return errors.New("relay is required with the private_relay strategy")
+		}
+	default:
+		return fmt.Errorf("invalid strategy: %s", c.Strategy)
+	}
+
+	for _, endpoint := range append([]string{c.Relay}, c.Endpoints...) {
+		if endpoint == "" {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid endpoint %q", endpoint)
+		}
+	}
+	return nil
+}
+
+// fanOutTimeout bounds the sends of a fan_out broadcast, they are not cancelled with the broadcast so the
+// endpoints left once one accepted the tx still deliver it
+const fanOutTimeout = 30 * time.Second
+
+// sendFunc sends a signed tx through one endpoint, a refusal of the node is a broadcast_rejected
+// TransactionError. It returns the name of the endpoint which accepted the tx when it picks among
+// several, empty otherwise.
+type sendFunc func(ctx context.Context, signed []byte) (string, error)
+
+type broadcastEndpoint struct {
+	name string // host of the url, the rest of it may hold an api key
+	send sendFunc
+}
+
+// broadcaster sends the signed txs of a chain with its broadcast strategy
+type broadcaster struct {
+	strategy  feetypes.BroadcastStrategy
+	endpoints []broadcastEndpoint
+}
+
+// newBroadcaster sets up the endpoints of the strategy of config, dial connects to one of them. The
+// provider is only used by the single and fan_out strategies.
+func newBroadcaster(config ChainConfig, provider sendFunc, dial func(endpoint string) (sendFunc, error)) (*broadcaster, error) {
+	b := &broadcaster{strategy: config.Broadcast.Strategy}
+
+	var endpoints []string
+	switch b.strategy {
+	case feetypes.BroadcastStrategyPrivateRelay:
+		endpoints = []string{config.Broadcast.Relay}
+	case feetypes.BroadcastStrategyFanOut:
+		b.endpoints = append(b.endpoints, broadcastEndpoint{name: evmrpc.Name(config.Provider), send: provider})
+		endpoints = config.Broadcast.Endpoints
+	default:
+		b.endpoints = append(b.endpoints, broadcastEndpoint{name: evmrpc.Name(config.Provider), send: provider})
+	}
+
+	for _, endpoint := range endpoints {
+		send, err := dial(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial %s: %w", evmrpc.Name(endpoint), err)
+		}
+		b.endpoints = append(b.endpoints, broadcastEndpoint{name: evmrpc.Name(endpoint), send: send})
+	}
+	return b, nil
+}
+
+// broadcast sends the signed tx, it returns the name of the endpoint which accepted it. A fan_out
+// broadcast is rejected only when every endpoint rejected it, an endpoint which could not be reached
+// may still have the tx.
+func (b *broadcaster) broadcast(ctx context.Context, signed []byte) (string, error) {
+	if len(b.endpoints) == 1 {
+		return b.endpoints[0].broadcast(ctx, signed)
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+	// buffered, the sends outlive the call once an endpoint accepted the tx
+	results := make(chan result, len(b.endpoints))
+	sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), fanOutTimeout)
+	var wg sync.WaitGroup
+	for _, e := range b.endpoints {
+		wg.Add(1)
+		go func(e broadcastEndpoint) {
+			defer wg.Done()
+			name, err := e.broadcast(sendCtx, signed)
+			if err != nil {
+				name = e.name
+			}
+			results <- result{name: name, err: err}
+		}(e)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+
+	var errs []string
+	rejected := true
+	for range b.endpoints {
+		r := <-results
+		if r.err == nil {
+			return r.name, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", r.name, r.err.Error()))
+		rejected = rejected && isTxError(r.err, feetypes.TxErrorBroadcastRejected)
+	}
+
+	message := strings.Join(errs, "; ")
+	if rejected {
+		return "", &feetypes.TransactionError{
+			Code:    feetypes.TxErrorBroadcastRejected,
+			Message: message,
+		}
+	}
+	return "", fmt.Errorf("no endpoint accepted the tx: %s", message)
+}
+
+// broadcast sends the signed tx through the endpoint, it returns the name of the endpoint which accepted it
+func (e broadcastEndpoint) broadcast(ctx context.Context, signed []byte) (string, error) {
+	name, err := e.send(ctx, signed)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = e.name
+	}
+	return name, nil
+}
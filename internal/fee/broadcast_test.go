@@ -0,0 +1,49 @@
+package fee
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+func TestFanOutBroadcastOutlivesCaller(t *testing.T) {
+	delivered := make(chan error, 1)
+	slow := func(ctx context.Context, _ []byte) (string, error) {
+		select {
+		case <-ctx.Done():
+			delivered <- ctx.Err()
+			return "", ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+			delivered <- nil
+			return "", nil
+		}
+	}
+	fast := func(context.Context, []byte) (string, error) { return "", nil }
+
+	b := &broadcaster{
+		strategy: feetypes.BroadcastStrategyFanOut,
+		endpoints: []broadcastEndpoint{
+			{name: "fast.test", send: fast},
+			{name: "slow.test", send: slow},
+		},
+	}
+
+	// the per-vault ctx is cancelled as soon as the broadcast returns
+	ctx, cancel := context.WithCancel(context.Background())
+	name, err := b.broadcast(ctx, []byte("tx"))
+	cancel()
+	if err != nil || name != "fast.test" {
+		t.Fatalf("broadcast = %q, %v, want fast.test", name, err)
+	}
+
+	select {
+	case err := <-delivered:
+		if err != nil {
+			t.Errorf("slow endpoint send cancelled: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow endpoint send did not return")
+	}
+}
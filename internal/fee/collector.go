@@ -0,0 +1,162 @@
+package fee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gagliardetto/solana-go/rpc"
+
+	v1 "github.com/vultisig/commondata/go/vultisig/vault/v1"
+	"github.com/vultisig/mobile-tss-lib/tss"
+	vtypes "github.com/vultisig/verifier/types"
+	"github.com/vultisig/vultisig-go/common"
+
+	"github.com/vultisig/feeplugin/internal/evmrpc"
+)
+
+// Collector is the chain specific part of fee collection, the fee pipeline only goes through it. There
+// is one implementation per chain family, picked by common.Chain in newCollector.
+type Collector interface {
+	Chain() common.Chain
+	// Config returns the USDC and treasury settings of the chain
+	Config() ChainConfig
+	// Prices values the native coin of the chain, nil without a price feed
+	Prices() PriceSource
+
+	// Address derives the address of the vault on the chain
+	Address(vault *v1.Vault) (string, error)
+	// SameAddress reports whether a and b are the same address in the address format of the chain
+	SameAddress(a, b string) bool
+	// Balance returns how much of asset the address holds, the native coin for NativeAsset
+	Balance(ctx context.Context, address, asset string) (*big.Int, error)
+
+	// BuildTransfer builds an unsigned transfer of amount of asset, the native coin for NativeAsset
+	BuildTransfer(ctx context.Context, from, to, asset string, amount *big.Int) ([]byte, error)
+	// TxInfo decodes an unsigned tx built by the collector
+	TxInfo(tx []byte) (*TxInfo, error)
+	// SignRequest wraps an unsigned tx into the keysign request of the policy
+	SignRequest(policy vtypes.PluginPolicy, txID string, tx []byte) (*vtypes.PluginKeysignRequest, error)
+
+	// SignedTx assembles the raw tx ready to broadcast from the unsigned tx and its signatures
+	SignedTx(tx []byte, sigs map[string]tss.KeysignResponse) ([]byte, error)
+	// Signer returns the address whose key signed the signed tx, errInvalidSignature if it is not a valid
+	// signature of the tx
+	Signer(signed []byte) (string, error)
+	// TxHash computes the hash of a signed tx
+	TxHash(signed []byte) (string, error)
+	// Broadcast sends a signed tx with the broadcast strategy of the chain, it returns the endpoint which
+	// accepted it. It can be sent again after a crash as the hash stays the same.
+	Broadcast(ctx context.Context, signed []byte) (string, error)
+	// Receipt looks up the on-chain outcome of a broadcasted tx
+	Receipt(ctx context.Context, hash string) (*Receipt, error)
+}
+
+// Replacer is implemented by the collectors of chains where a pending tx can be replaced by another one
+// with the same nonce
+type Replacer interface {
+	// SpeedUp returns the tx with its fees raised by percent, or to the current network fees if higher
+	SpeedUp(ctx context.Context, tx []byte, percent uint64) ([]byte, error)
+	// Cancel returns a zero value transfer to the address, with the nonce of tx and its fees raised by percent.
+	// The fee pipeline sends it to the treasury, the only recipient the plugin policy allows.
+	Cancel(ctx context.Context, from, to string, tx []byte, percent uint64) ([]byte, error)
+}
+
+// GasPricer is implemented by the collectors of chains with a fee market
+type GasPricer interface {
+	// GasPrices returns the tip and fee cap per gas the next fee tx would be priced at
+	GasPrices(ctx context.Context) (*big.Int, *big.Int, error)
+	// GasPrice returns what a unit of gas costs in the next block, its base fee plus the current tip
+	GasPrice(ctx context.Context) (*big.Int, error)
+}
+
+// ContractCaller is implemented by the collectors of chains with contract calls, needed for swaps
+type ContractCaller interface {
+	// Allowance returns how much of token the owner allows spender to move
+	Allowance(ctx context.Context, token, owner, spender string) (*big.Int, error)
+	// BuildCall builds an unsigned call of the contract
+	BuildCall(ctx context.Context, from, contract string, value *big.Int, data []byte) ([]byte, error)
+}
+
+// Simulator is implemented by the collectors of chains where a tx can be run without being sent
+type Simulator interface {
+	// Simulate runs the unsigned tx from the address against the pending state, it returns the gas the tx
+	// uses or why it would fail
+	Simulate(ctx context.Context, from string, tx []byte) (uint64, error)
+}
+
+var errInvalidSignature = errors.New("invalid signature")
+
+// NativeAsset is the asset of the native coin of a chain
+const NativeAsset = "0x0000000000000000000000000000000000000000"
+
+// TxInfo is what the pipeline needs to know about an unsigned tx
+type TxInfo struct {
+	Nonce uint64
+	Value *big.Int // native coin sent along
+	Fee   *big.Int // worst case network fee, in native base units
+}
+
+type ReceiptStatus int
+
+const (
+	ReceiptStatusNotFound ReceiptStatus = iota // unknown to the node
+	ReceiptStatusPending                       // waiting in the mempool
+	ReceiptStatusSuccess
+	ReceiptStatusFailed
+)
+
+// Receipt is the on-chain outcome of a tx
+type Receipt struct {
+	Status        ReceiptStatus
+	Confirmations uint64 // of a mined tx
+	Finalized     bool   // can't be rolled back anymore, confirmed whatever Jobs.Post.SuccessConfirmations
+}
+
+// Mined reports whether the tx is in a block, successful or not
+func (r *Receipt) Mined() bool {
+	return r.Status == ReceiptStatusSuccess || r.Status == ReceiptStatusFailed
+}
+
+// newCollector builds the collector of chain, EVM chains use the client dialed for them in rpcs
+func newCollector(
+	chain common.Chain,
+	config ChainConfig,
+	rpcs map[common.Chain]*evmrpc.Client,
+	priceMaxAge time.Duration,
+) (Collector, error) {
+	switch {
+	case chain.IsEvm():
+		client, ok := rpcs[chain]
+		if !ok {
+			return nil, fmt.Errorf("no rpc client for %s", chain.String())
+		}
+		return newEvmCollector(chain, config, client, priceMaxAge)
+	case chain == common.Solana:
+		return newSolanaCollector(config, rpc.New(config.Provider))
+	default:
+		return nil, fmt.Errorf("chain %s is not supported", chain.String())
+	}
+}
+
+// usdcAmount converts a debt amount, always in 6 decimals, to base units of the USDC token of the chain
+func usdcAmount(c Collector, amount uint64) *big.Int {
+	return scaleDecimals(new(big.Int).SetUint64(amount), usdcDecimals, int(c.Config().UsdcDecimals))
+}
+
+// debtAmount converts base units of the USDC token of the chain to a debt amount
+func debtAmount(c Collector, usdc *big.Int) uint64 {
+	return scaleDecimals(usdc, int(c.Config().UsdcDecimals), usdcDecimals).Uint64()
+}
+
+// collectorByName returns the collector of the chain named by a fee run
+func (fp *FeePlugin) collectorByName(name string) (Collector, error) {
+	for _, c := range fp.collectors {
+		if c.Chain().String() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("chain %s is not configured", name)
+}
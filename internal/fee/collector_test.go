@@ -0,0 +1,49 @@
+package fee
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+func TestSameAddress(t *testing.T) {
+	evmAddress := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	solanaAddress := solana.NewWallet().PublicKey().String()
+
+	tests := []struct {
+		name string
+		c    Collector
+		a, b string
+		want bool
+	}{
+		{name: "evm same", c: &evmCollector{}, a: evmAddress, b: evmAddress, want: true},
+		{name: "evm checksummed and lowercase", c: &evmCollector{}, a: evmAddress, b: strings.ToLower(evmAddress), want: true},
+		{name: "evm other address", c: &evmCollector{}, a: evmAddress, b: testVaultAddress},
+		{name: "evm invalid address", c: &evmCollector{}, a: evmAddress, b: "vault"},
+		{name: "solana same", c: &solanaCollector{}, a: solanaAddress, b: solanaAddress, want: true},
+		{name: "solana other case", c: &solanaCollector{}, a: solanaAddress, b: swapCase(solanaAddress)},
+		{name: "solana invalid address", c: &solanaCollector{}, a: solanaAddress, b: "vault"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.SameAddress(tt.a, tt.b); got != tt.want {
+				t.Errorf("SameAddress(%s, %s) = %t, want %t", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// swapCase flips the case of every letter of s
+func swapCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return r
+	}, s)
+}
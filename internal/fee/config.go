@@ -3,47 +3,242 @@ package fee
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/vultisig/vultisig-go/common"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
 )
 
+// supportedChains in collection order, a vault is collected on the first chain where it holds the debt
+// in USDC so the cheapest gas goes first
 var supportedChains = []common.Chain{
+	common.Arbitrum,
+	common.Base,
+	common.Polygon,
+	common.BscChain,
+	common.Solana,
 	common.Ethereum,
 }
 
-func getSupportedChainStrings() []string {
+func chainStrings(chains []common.Chain) []string {
 	var cc []string
-	for _, c := range supportedChains {
+	for _, c := range chains {
 		cc = append(cc, c.String())
 	}
 	return cc
 }
 
+const (
+	MaxFeeAmountModeSplit  = "split"  // debts above max_fee_amount are collected in capped instalments across runs
+	MaxFeeAmountModeReview = "review" // vaults with debts above max_fee_amount are flagged for manual review
+)
+
+const (
+	InsufficientBalanceModePartial = "partial" // collect what the vault holds and carry the rest of the debt to the next run
+	InsufficientBalanceModeSkip    = "skip"    // collect nothing and record the vault as having insufficient funds
+)
+
 // These are properties and parameters specific to the fee plugin config. They should be distinct from system/core config
 type FeeConfig struct {
-	Type            string `mapstructure:"type,omitempty"`
-	Version         string `mapstructure:"version,omitempty"`
-	MaxFeeAmount    uint64 `mapstructure:"max_fee_amount,omitempty"`   // Policies that are created/submitted which do not have this amount will be rejected.
-	UsdcAddress     string `mapstructure:"usdc_address,omitempty"`     // The address of the USDC token on the Ethereum blockchain.
-	TreasuryAddress string `mapstructure:"treasury_address,omitempty"` // The address of the Vultisig Treasury on the Ethereum blockchain.
-	VerifierToken   string `mapstructure:"verifier_token,omitempty"`   // The token to use for the verifier API.
-	ChainId         uint64 `mapstructure:"chain_id,omitempty"`         // The chain ID of the Ethereum blockchain.
-	EthProvider     string `mapstructure:"eth_provider,omitempty"`     // The Ethereum provider to use for the fee plugin.
-	Jobs            struct {
+	Type                    string                 `mapstructure:"type,omitempty"`
+	Version                 string                 `mapstructure:"version,omitempty"`
+	MaxFeeAmount            uint64                 `mapstructure:"max_fee_amount,omitempty"`            // Policies that are created/submitted which do not have this amount will be rejected. No fee transfer above it is ever signed.
+	MaxFeeAmountMode        string                 `mapstructure:"max_fee_amount_mode,omitempty"`       // What to do with debts above max_fee_amount, split or review.
+	InsufficientBalanceMode string                 `mapstructure:"insufficient_balance_mode,omitempty"` // What to do when the vault holds less USDC than the debt, partial or skip.
+	UsdcAddress             string                 `mapstructure:"usdc_address,omitempty"`              // The address of the USDC token on the Ethereum blockchain.
+	TreasuryAddress         string                 `mapstructure:"treasury_address,omitempty"`          // The address of the Vultisig Treasury on the Ethereum blockchain.
+	VerifierToken           string                 `mapstructure:"verifier_token,omitempty"`            // The token to use for the verifier API.
+	ChainId                 uint64                 `mapstructure:"chain_id,omitempty"`                  // The chain ID of the Ethereum blockchain.
+	EthProvider             string                 `mapstructure:"eth_provider,omitempty"`              // The Ethereum provider to use for the fee plugin.
+	MinCollectibleAmount    uint64                 `mapstructure:"min_collectible_amount,omitempty"`    // Smaller debts keep accruing until they reach it, in USDC base units.
+	MaxGasFeeRatio          float64                `mapstructure:"max_gas_fee_ratio,omitempty"`         // Max gas cost of a fee tx as a share of the collected amount, 0 disables it. Every EVM chain needs a price_feed_address with it.
+	PriceFeedAddress        string                 `mapstructure:"price_feed_address,omitempty"`        // The Chainlink ETH/USD aggregator on the Ethereum blockchain.
+	PriceMaxAge             time.Duration          `mapstructure:"price_max_age,omitempty"`             // How old the ETH/USD price can be before it is rejected.
+	NativeFallback          bool                   `mapstructure:"native_fallback,omitempty"`           // Collect the debt in ETH when the vault does not hold enough USDC.
+	NativeHaircut           float64                `mapstructure:"native_haircut,omitempty"`            // Share added on top of the ETH value of the debt, covers the slippage of converting it.
+	Chains                  map[string]ChainConfig `mapstructure:"chains,omitempty"`                    // Per chain settings keyed by chain name, e.g. arbitrum. The legacy top level fields are the Ethereum ones.
+	Swap                    struct {
+		Enabled bool     `mapstructure:"enabled,omitempty"` // Swap another asset of the vault to USDC when it does not hold enough USDC, after the native fallback.
+		ApiKey  string   `mapstructure:"api_key,omitempty"` // The 1inch API key.
+		Assets  []string `mapstructure:"assets,omitempty"`  // Assets swapped from, in order of preference, the zero address for ETH.
+		Haircut float64  `mapstructure:"haircut,omitempty"` // Share quoted on top of the debt, covers the slippage of the swap.
+	} `mapstructure:"swap,omitempty"`
+	Rpc struct {
+		HealthCheckInterval time.Duration `mapstructure:"health_check_interval,omitempty"` // How often the chain id and latest block of every EVM RPC provider are checked.
+		MaxBlockLag         uint64        `mapstructure:"max_block_lag,omitempty"`         // How many blocks a provider can be behind the most advanced provider of its chain before it is taken out of rotation.
+	} `mapstructure:"rpc,omitempty"`
+	Retry struct {
+		MaxAttempts uint64        `mapstructure:"max_attempts,omitempty"` // Consecutive failures after which a vault is dead-lettered, permanent errors dead-letter it at once.
+		Backoff     time.Duration `mapstructure:"backoff,omitempty"`      // How long a vault is skipped after its first failure, doubled with every further one.
+		MaxBackoff  time.Duration `mapstructure:"max_backoff,omitempty"`  // Cap on how long a failing vault is skipped.
+	} `mapstructure:"retry,omitempty"`
+	DryRun struct {
+		Enabled    bool   `mapstructure:"enabled,omitempty"`     // Plan the collection of every vault once, write the report and exit. Nothing is signed nor written.
+		ReportPath string `mapstructure:"report_path,omitempty"` // Where the JSON report of the dry run is written, stdout if not set.
+	} `mapstructure:"dry_run,omitempty"`
+	Jobs struct {
 		Load struct {
 			MaxConcurrentJobs uint64 `mapstructure:"max_concurrent_jobs,omitempty"` //How many consecutive tasks can take place
 			Cronexpr          string `mapstructure:"cronexpr,omitempty"`            // Cron link expression on how often these tasks should run
 		} `mapstructure:"load,omitempty"`
 		Transact struct {
-			MaxConcurrentJobs uint64 `mapstructure:"max_concurrent_jobs,omitempty"` //How many consecutive tasks can take place
-			Cronexpr          string `mapstructure:"cronexpr,omitempty"`            // Cron link expression on how often these tasks should run
+			MaxConcurrentJobs    uint64        `mapstructure:"max_concurrent_jobs,omitempty"`      //How many consecutive tasks can take place
+			Cronexpr             string        `mapstructure:"cronexpr,omitempty"`                 // Cron link expression on how often these tasks should run
+			MaxFeePerGas         uint64        `mapstructure:"max_fee_per_gas,omitempty"`          // Ceiling in wei on maxFeePerGas, vaults are deferred above it. 0 disables it
+			MaxPriorityFeePerGas uint64        `mapstructure:"max_priority_fee_per_gas,omitempty"` // Ceiling in wei on maxPriorityFeePerGas, vaults are deferred above it. 0 disables it
+			PreferredWindow      TimeWindow    `mapstructure:"preferred_window,omitempty"`         // When deferred vaults are retried, the next run if not set
+			VaultTimeout         time.Duration `mapstructure:"vault_timeout,omitempty"`            // How long the collection of a single vault, keysign included, can take before it is abandoned
 		} `mapstructure:"transact,omitempty"`
 		Post struct {
-			SuccessConfirmations uint64 `mapstructure:"success_confirmations,omitempty"` //How many consecutive tasks can take place
-			Cronexpr             string `mapstructure:"cronexpr,omitempty"`              // Cron link expression on how often these tasks should run
-			MaxConcurrentJobs    uint64 `mapstructure:"max_concurrent_jobs,omitempty"`
+			SuccessConfirmations uint64        `mapstructure:"success_confirmations,omitempty"` // How many confirmations a fee tx needs before the run is completed
+			DropAfter            time.Duration `mapstructure:"drop_after,omitempty"`            // How long a fee tx can be unknown to the node before the run is failed as dropped
+			StuckAfter           time.Duration `mapstructure:"stuck_after,omitempty"`           // How long a fee tx can stay in the mempool before it is replaced
+			MaxSpeedups          uint64        `mapstructure:"max_speedups,omitempty"`          // How many speed-ups are sent for a stuck fee tx before it is cancelled
+			GasBumpPercent       uint64        `mapstructure:"gas_bump_percent,omitempty"`      // By how much the fees of a replacement tx are raised over the stuck one
+			Cronexpr             string        `mapstructure:"cronexpr,omitempty"`              // Cron link expression on how often these tasks should run
+			MaxConcurrentJobs    uint64        `mapstructure:"max_concurrent_jobs,omitempty"`
 		} `mapstructure:"post,omitempty"`
+	} `mapstructure:"jobs,omitempty"`
+}
+
+// ChainConfig are the settings of fee collection on one chain
+type ChainConfig struct {
+	Provider             string   `mapstructure:"provider,omitempty"`                 // The RPC provider of the chain.
+	Fallbacks            []string `mapstructure:"fallbacks,omitempty"`                // RPC providers requests fail over to, in order, when the provider is down or unhealthy. EVM chains only.
+	UsdcAddress          string   `mapstructure:"usdc_address,omitempty"`             // The address of the USDC token on the chain, its mint on Solana.
+	UsdcDecimals         uint8    `mapstructure:"usdc_decimals,omitempty"`            // Decimals of the USDC token on the chain, 6 if not set. Debts are always in 6 decimals.
+	TreasuryAddress      string   `mapstructure:"treasury_address,omitempty"`         // The address of the Vultisig Treasury on the chain, on Solana the wallet owning its USDC token account.
+	PriceFeedAddress     string   `mapstructure:"price_feed_address,omitempty"`       // The Chainlink aggregator of the native coin in USD on the chain, not supported on Solana.
+	MaxFeePerGas         uint64   `mapstructure:"max_fee_per_gas,omitempty"`          // Ceiling in wei on maxFeePerGas, vaults are deferred above it. 0 disables it
+	MaxPriorityFeePerGas uint64   `mapstructure:"max_priority_fee_per_gas,omitempty"` // Ceiling in wei on maxPriorityFeePerGas, vaults are deferred above it. 0 disables it
+
+	Broadcast BroadcastConfig `mapstructure:"broadcast,omitempty"` // How signed fee txs are sent, through the provider if not set.
+}
+
+// ChainConfig returns the settings of chain and whether fees are collected on it. Ethereum is always
+// collected on, the legacy top level fields fill what its chains entry leaves empty.
+func (c *FeeConfig) ChainConfig(chain common.Chain) (ChainConfig, bool) {
+	var cc ChainConfig
+	ok := false
+	for name, entry := range c.Chains {
+		if strings.EqualFold(name, chain.String()) {
+			cc, ok = entry, true
+			break
+		}
+	}
+
+	if chain == common.Ethereum {
+		ok = true
+		if cc.Provider == "" {
+			cc.Provider = c.EthProvider
+		}
+		if cc.UsdcAddress == "" {
+			cc.UsdcAddress = c.UsdcAddress
+		}
+		if cc.TreasuryAddress == "" {
+			cc.TreasuryAddress = c.TreasuryAddress
+		}
+		if cc.PriceFeedAddress == "" {
+			cc.PriceFeedAddress = c.PriceFeedAddress
+		}
+		if cc.MaxFeePerGas == 0 {
+			cc.MaxFeePerGas = c.Jobs.Transact.MaxFeePerGas
+		}
+		if cc.MaxPriorityFeePerGas == 0 {
+			cc.MaxPriorityFeePerGas = c.Jobs.Transact.MaxPriorityFeePerGas
+		}
+	}
+
+	if cc.UsdcDecimals == 0 {
+		cc.UsdcDecimals = usdcDecimals
 	}
+	if cc.Broadcast.Strategy == "" {
+		cc.Broadcast.Strategy = feetypes.BroadcastStrategySingle
+	}
+	return cc, ok
+}
+
+// Providers returns the RPC providers of the chain in the order requests try them
+func (c ChainConfig) Providers() []string {
+	return append([]string{c.Provider}, c.Fallbacks...)
+}
+
+// EnabledChains returns the chains fees are collected on, in collection order
+func (c *FeeConfig) EnabledChains() []common.Chain {
+	var chains []common.Chain
+	for _, chain := range supportedChains {
+		if _, ok := c.ChainConfig(chain); ok {
+			chains = append(chains, chain)
+		}
+	}
+	return chains
+}
+
+// TimeWindow is a daily UTC time window, e.g. 02:00 to 06:00. It may span midnight.
+type TimeWindow struct {
+	Start string `mapstructure:"start,omitempty"` // 15:04
+	End   string `mapstructure:"end,omitempty"`   // 15:04
+}
+
+func (w TimeWindow) IsSet() bool {
+	return w.Start != "" || w.End != ""
+}
+
+func (w TimeWindow) parse() (time.Duration, time.Duration, error) {
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid window start %q: %w", w.Start, err)
+	}
+	end, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid window end %q: %w", w.End, err)
+	}
+	if start.Equal(end) {
+		return 0, 0, errors.New("window start and end must differ")
+	}
+	return timeOfDay(start), timeOfDay(end), nil
+}
+
+// Contains reports whether t is inside the window
+func (w TimeWindow) Contains(t time.Time) bool {
+	start, end, err := w.parse()
+	if err != nil {
+		return false
+	}
+	offset := timeOfDay(t.UTC())
+	if start < end {
+		return offset >= start && offset < end
+	}
+	return offset >= start || offset < end
+}
+
+// NextStart returns the next opening of the window after t
+func (w TimeWindow) NextStart(t time.Time) time.Time {
+	start, _, err := w.parse()
+	if err != nil {
+		return t
+	}
+	t = t.UTC()
+	next := t.Truncate(24 * time.Hour).Add(start)
+	if !next.After(t) {
+		next = next.Add(24 * time.Hour)
+	}
+	return next
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+func isSupportedChain(chain common.Chain) bool {
+	for _, c := range supportedChains {
+		if c == chain {
+			return true
+		}
+	}
+	return false
 }
 
 func DefaultFeeConfig() *FeeConfig {
@@ -52,11 +247,37 @@ func DefaultFeeConfig() *FeeConfig {
 	c.Type = PLUGIN_TYPE
 	c.Version = "1.0.0"
 	c.MaxFeeAmount = 500e6 // 500 USDC
+	c.MaxFeeAmountMode = MaxFeeAmountModeSplit
+	c.InsufficientBalanceMode = InsufficientBalanceModePartial
+	c.MinCollectibleAmount = 1e6 // 1 USDC
+	c.MaxGasFeeRatio = 0.1
+	c.PriceFeedAddress = "0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419"
+	c.PriceMaxAge = 2 * time.Hour
+	c.NativeHaircut = 0.02
+	c.Swap.Haircut = 0.02
+	c.Swap.Assets = []string{
+		"0x0000000000000000000000000000000000000000", // ETH
+		"0xdAC17F958D2ee523a2206206994597C13D831ec7", // USDT
+		"0x6B175474E89094C44Da98b954EedeAC495271d0F", // DAI
+		"0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", // WETH
+	}
+
+	c.Rpc.HealthCheckInterval = 30 * time.Second
+	c.Rpc.MaxBlockLag = 10
+
+	c.Retry.MaxAttempts = 5
+	c.Retry.Backoff = 10 * time.Minute
+	c.Retry.MaxBackoff = 24 * time.Hour
 
 	c.Jobs.Load.MaxConcurrentJobs = 10
 	c.Jobs.Transact.MaxConcurrentJobs = 10
+	c.Jobs.Transact.VaultTimeout = 5 * time.Minute
 	c.Jobs.Post.MaxConcurrentJobs = 10
 	c.Jobs.Post.SuccessConfirmations = 20
+	c.Jobs.Post.DropAfter = 30 * time.Minute
+	c.Jobs.Post.StuckAfter = 10 * time.Minute
+	c.Jobs.Post.MaxSpeedups = 3
+	c.Jobs.Post.GasBumpPercent = 20
 
 	c.Jobs.Load.Cronexpr = "@every 2m"
 	c.Jobs.Transact.Cronexpr = "0 12 * * 5"
@@ -78,6 +299,73 @@ func (c *FeeConfig) Validate() error {
 		return errors.New("chain_id is required")
 	}
 
+	if c.MaxFeeAmount == 0 {
+		return errors.New("max_fee_amount is required")
+	}
+
+	if c.MaxFeeAmountMode != MaxFeeAmountModeSplit && c.MaxFeeAmountMode != MaxFeeAmountModeReview {
+		return fmt.Errorf("invalid max_fee_amount_mode: %s", c.MaxFeeAmountMode)
+	}
+
+	if c.InsufficientBalanceMode != InsufficientBalanceModePartial && c.InsufficientBalanceMode != InsufficientBalanceModeSkip {
+		return fmt.Errorf("invalid insufficient_balance_mode: %s", c.InsufficientBalanceMode)
+	}
+
+	if c.MaxGasFeeRatio < 0 || c.MaxGasFeeRatio > 1 {
+		return errors.New("max_gas_fee_ratio must be between 0 and 1")
+	}
+
+	if c.MaxGasFeeRatio > 0 && c.PriceFeedAddress == "" {
+		return errors.New("price_feed_address is required with max_gas_fee_ratio")
+	}
+
+	if c.NativeHaircut < 0 || c.NativeHaircut > 0.5 {
+		return errors.New("native_haircut must be between 0 and 0.5")
+	}
+
+	if c.NativeFallback && c.PriceFeedAddress == "" {
+		return errors.New("price_feed_address is required with native_fallback")
+	}
+
+	for name := range c.Chains {
+		chain, err := common.FromString(name)
+		if err != nil {
+			return fmt.Errorf("invalid chains entry: %w", err)
+		}
+		if !isSupportedChain(chain) {
+			return fmt.Errorf("chain %s is not supported", chain.String())
+		}
+		cc, _ := c.ChainConfig(chain)
+		err = cc.Broadcast.validate()
+		if err != nil {
+			return fmt.Errorf("invalid broadcast for chain %s: %w", chain.String(), err)
+		}
+		if chain == common.Ethereum {
+			continue
+		}
+		if cc.Provider == "" || cc.UsdcAddress == "" || cc.TreasuryAddress == "" {
+			return fmt.Errorf("provider, usdc_address and treasury_address are required for chain %s", chain.String())
+		}
+		if !chain.IsEvm() && cc.PriceFeedAddress != "" {
+			return fmt.Errorf("price_feed_address is not supported for chain %s", chain.String())
+		}
+		// the gas of every EVM chain is priced by its own feed, without one the ratio would go unchecked
+		if chain.IsEvm() && c.MaxGasFeeRatio > 0 && cc.PriceFeedAddress == "" {
+			return fmt.Errorf("price_feed_address is required for chain %s with max_gas_fee_ratio", chain.String())
+		}
+		if !chain.IsEvm() && len(cc.Fallbacks) != 0 {
+			return fmt.Errorf("fallbacks is not supported for chain %s", chain.String())
+		}
+	}
+
+	if c.Swap.Haircut < 0 || c.Swap.Haircut > 0.5 {
+		return errors.New("swap.haircut must be between 0 and 0.5")
+	}
+
+	if c.Swap.Enabled && len(c.Swap.Assets) == 0 {
+		return errors.New("swap.assets is required with swap.enabled")
+	}
+
 	//if c.EthProvider == "" {
 	//	return errors.New("eth_provider is required")
 	//}
@@ -91,5 +379,43 @@ func (c *FeeConfig) Validate() error {
 		return errors.New("max_concurrent_jobs must be greater than 0 and less than 100")
 	}
 
+	if c.Rpc.HealthCheckInterval <= 0 {
+		return errors.New("rpc.health_check_interval must be greater than 0")
+	}
+
+	if c.Retry.MaxAttempts < 1 {
+		return errors.New("retry.max_attempts must be greater than 0")
+	}
+
+	if c.Retry.Backoff <= 0 || c.Retry.MaxBackoff < c.Retry.Backoff {
+		return errors.New("retry.backoff must be greater than 0 and at most retry.max_backoff")
+	}
+
+	if c.Jobs.Transact.VaultTimeout <= 0 {
+		return errors.New("vault_timeout must be greater than 0")
+	}
+
+	if c.Jobs.Load.Cronexpr == "" ||
+		c.Jobs.Transact.Cronexpr == "" ||
+		c.Jobs.Post.Cronexpr == "" {
+		return errors.New("cronexpr is required for every job")
+	}
+
+	if c.Jobs.Transact.PreferredWindow.IsSet() {
+		_, _, err := c.Jobs.Transact.PreferredWindow.parse()
+		if err != nil {
+			return fmt.Errorf("invalid preferred_window: %w", err)
+		}
+	}
+
+	if c.Jobs.Post.SuccessConfirmations < 1 {
+		return errors.New("success_confirmations must be greater than 0")
+	}
+
+	// nodes only accept a replacement tx which raises both fee caps by at least 10%
+	if c.Jobs.Post.GasBumpPercent < 10 {
+		return errors.New("gas_bump_percent must be at least 10")
+	}
+
 	return nil
 }
@@ -0,0 +1,44 @@
+package fee
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateGasRatioPriceFeeds(t *testing.T) {
+	arbitrum := ChainConfig{
+		Provider:        "https://arbitrum.test",
+		UsdcAddress:     testUsdcAddress,
+		TreasuryAddress: testTreasuryAddress,
+	}
+	withFeed := arbitrum
+	withFeed.PriceFeedAddress = "0x639Fe6ab55C921f74e7fac1ee960C0B6293ba612"
+
+	tests := []struct {
+		name    string
+		ratio   float64
+		chain   ChainConfig
+		wantErr string
+	}{
+		{name: "feed on the chain", ratio: 0.1, chain: withFeed},
+		{name: "no feed on the chain", ratio: 0.1, chain: arbitrum, wantErr: "price_feed_address is required for chain Arbitrum"},
+		{name: "ratio disabled", ratio: 0, chain: arbitrum},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultFeeConfig()
+			c.VerifierToken = "token"
+			c.MaxGasFeeRatio = tt.ratio
+			c.Chains = map[string]ChainConfig{"arbitrum": tt.chain}
+
+			err := c.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
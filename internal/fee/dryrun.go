@@ -0,0 +1,236 @@
+package fee
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+// actions of a dry run vault besides the planActions
+const (
+	dryRunActionInProgress = "in_progress" // a fee run of the vault is not finished yet
+	dryRunActionLeased     = "leased"      // another replica is collecting the vault
+	dryRunActionBackoff    = "backoff"     // the vault failed lately, it is skipped until its next retry
+	dryRunActionDeadLetter = "dead_letter" // the vault kept failing, it is not collected until reactivated
+	dryRunActionRevert     = "revert"      // the fee tx would fail on chain
+	dryRunActionError      = "error"
+)
+
+// DryRunReport is what a fees:transaction run would do with every vault
+type DryRunReport struct {
+	StartedAt time.Time     `json:"started_at"`
+	Vaults    []DryRunVault `json:"vaults"`
+}
+
+// DryRunVault is the planned collection of a vault. Amount is the debt collected in USDC base units,
+// AssetAmount and Balance are in base units of Asset.
+type DryRunVault struct {
+	PublicKey    string `json:"public_key"`
+	Action       string `json:"action"`
+	Reason       string `json:"reason,omitempty"` // why the vault is not collected
+	Fees         int    `json:"fees"`
+	Debits       string `json:"debits,omitempty"`
+	Credits      string `json:"credits,omitempty"`
+	Net          string `json:"net,omitempty"`
+	Amount       uint64 `json:"amount,omitempty"`
+	Partial      bool   `json:"partial,omitempty"`
+	Chain        string `json:"chain,omitempty"`
+	Address      string `json:"address,omitempty"`
+	Method       string `json:"method,omitempty"`
+	Asset        string `json:"asset,omitempty"`
+	AssetAmount  string `json:"asset_amount,omitempty"`
+	Balance      string `json:"balance,omitempty"`
+	EstimatedGas uint64 `json:"estimated_gas,omitempty"`
+	NetworkFee   string `json:"network_fee,omitempty"` // worst case, in native base units
+}
+
+// DryRun plans the collection of every vault the fees:transaction stage would pick from the fees the verifier
+// reports for it, and simulates the fee txs. The vaults it leaves out are listed with the reason. Nothing
+// is signed, written to the db besides the vault leases, or reported to the verifier.
+func (fp *FeePlugin) DryRun(ctx context.Context) (*DryRunReport, error) {
+	pks, err := fp.db.GetPublicKeysByStatus(ctx, feetypes.PublicKeyStatusActive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public keys: %w", err)
+	}
+	held, err := fp.db.GetHeldPublicKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get held public keys: %w", err)
+	}
+	fp.logger.WithFields(logrus.Fields{
+		"pks":  len(pks),
+		"held": len(held),
+	}).Info("dry run of fee collection")
+
+	report := &DryRunReport{StartedAt: time.Now()}
+	for _, pk := range pks {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		vault := fp.dryRunLeasedVault(ctx, pk)
+		fp.logger.WithFields(logrus.Fields{
+			"pubkey": pk,
+			"action": vault.Action,
+			"reason": vault.Reason,
+		}).Info("dry run of vault")
+		report.Vaults = append(report.Vaults, vault)
+	}
+	for _, key := range held {
+		report.Vaults = append(report.Vaults, dryRunHeldVault(key))
+	}
+	return report, nil
+}
+
+// dryRunLeasedVault plans the collection of the vault under its lease, as processVault does
+func (fp *FeePlugin) dryRunLeasedVault(ctx context.Context, pk string) DryRunVault {
+	release, leased, err := fp.leaseVault(ctx, pk)
+	if err != nil {
+		return DryRunVault{PublicKey: pk, Action: dryRunActionError, Reason: fmt.Sprintf("failed to lease vault: %v", err)}
+	}
+	if !leased {
+		return DryRunVault{PublicKey: pk, Action: dryRunActionLeased, Reason: "vault leased by another replica"}
+	}
+	defer release()
+
+	return fp.dryRunVault(ctx, pk)
+}
+
+// dryRunHeldVault reports why the fees:transaction stage leaves the vault out
+func dryRunHeldVault(key *feetypes.PublicKey) DryRunVault {
+	v := DryRunVault{PublicKey: key.PublicKey}
+	reason := func(r *string) string {
+		if r == nil {
+			return ""
+		}
+		return *r
+	}
+
+	switch key.Status {
+	case feetypes.PublicKeyStatusReview:
+		v.Action = string(planActionReview)
+		v.Reason = reason(key.StatusReason)
+	case feetypes.PublicKeyStatusDeadLetter:
+		v.Action = dryRunActionDeadLetter
+		v.Reason = reason(key.StatusReason)
+	case feetypes.PublicKeyStatusActive:
+		v.Action = dryRunActionBackoff
+		v.Reason = fmt.Sprintf("%d failures, last: %s", key.FailedAttempts, reason(key.LastError))
+		if key.NextRetryAt != nil {
+			v.Reason = fmt.Sprintf("%s, next retry at %s", v.Reason, key.NextRetryAt.Format(time.RFC3339))
+		}
+	default:
+		v.Action = string(key.Status)
+		v.Reason = reason(key.StatusReason)
+	}
+	return v
+}
+
+func (fp *FeePlugin) dryRunVault(ctx context.Context, pk string) DryRunVault {
+	v := DryRunVault{PublicKey: pk}
+	fail := func(err error) DryRunVault {
+		v.Action = dryRunActionError
+		v.Reason = err.Error()
+		return v
+	}
+
+	active, err := fp.db.HasActiveFeeRun(ctx, pk)
+	if err != nil {
+		return fail(fmt.Errorf("failed to check active fee runs: %w", err))
+	}
+	if active {
+		v.Action = dryRunActionInProgress
+		return v
+	}
+
+	verifierFees, err := fp.verifierApi.GetPublicKeysFees(pk)
+	if err != nil {
+		return fail(fmt.Errorf("failed to get fees: %w", verifierError(err)))
+	}
+	v.Fees = len(verifierFees)
+	if len(verifierFees) == 0 {
+		v.Action = string(planActionNone)
+		return v
+	}
+
+	fees := make([]feetypes.Fee, 0, len(verifierFees))
+	for _, fee := range verifierFees {
+		fees = append(fees, feetypes.Fee{
+			FeeID:     fee.ID,
+			PublicKey: pk,
+			PolicyID:  fee.PolicyID,
+			TxType:    string(fee.TxType),
+			Amount:    fee.Amount,
+		})
+	}
+
+	plan, err := fp.planCollection(ctx, pk, fees)
+	if err != nil {
+		return fail(err)
+	}
+
+	v.Action = string(plan.action)
+	v.Debits = plan.breakdown.Debits.String()
+	v.Credits = plan.breakdown.Credits.String()
+	v.Net = plan.breakdown.Net.String()
+	v.Amount = plan.amount
+	v.Partial = plan.partial
+	if plan.chain != nil {
+		v.Chain = plan.chain.Chain().String()
+	}
+	if plan.balance != nil {
+		v.Balance = plan.balance.String()
+	}
+
+	switch {
+	case plan.message != "":
+		v.Reason = plan.message
+	case plan.reason != "":
+		v.Reason = plan.reason
+	case plan.action == planActionReview:
+		v.Reason = fmt.Sprintf("debt %d is above max fee amount %d", plan.amount, fp.config.MaxFeeAmount)
+	case plan.action == planActionInsufficientFunds:
+		v.Reason = fmt.Sprintf("insufficient funds: usdc balance %s, debt %d", plan.balance.String(), plan.amount)
+	}
+
+	c := plan.collection
+	if c == nil {
+		return v
+	}
+	v.Address = plan.address
+	v.Method = string(c.method)
+	v.Asset = c.asset
+	v.AssetAmount = c.amount.String()
+
+	balance, err := c.chain.Balance(ctx, plan.address, c.asset)
+	if err != nil {
+		return fail(fmt.Errorf("failed to get balance: %w", err))
+	}
+	v.Balance = balance.String()
+
+	if plan.tx == nil {
+		return v
+	}
+	info, err := c.chain.TxInfo(plan.tx)
+	if err != nil {
+		return fail(err)
+	}
+	v.NetworkFee = info.Fee.String()
+
+	if sim, ok := c.chain.(Simulator); ok {
+		gas, err := sim.Simulate(ctx, plan.address, plan.tx)
+		if isTxError(err, feetypes.TxErrorSimulationRevert) {
+			v.Action = dryRunActionRevert
+			v.Reason = err.Error()
+			return v
+		}
+		if err != nil {
+			return fail(fmt.Errorf("failed to simulate fee tx: %w", err))
+		}
+		v.EstimatedGas = gas
+	}
+	return v
+}
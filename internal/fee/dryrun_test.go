@@ -0,0 +1,63 @@
+package fee
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+func TestDryRunKeySelection(t *testing.T) {
+	db := newFakeDB()
+	fp := newTestFeePlugin(t, newFakeCollector(), db, newFakeVerifier(t))
+
+	db.keys = []string{testPublicKey, "leased", "review", "dead", "failing"}
+	db.leases["leased"] = "another replica"
+	db.statuses["review"] = feetypes.PublicKeyStatusReview
+	db.reasons["review"] = "debt above max fee amount"
+	db.statuses["dead"] = feetypes.PublicKeyStatusDeadLetter
+	db.reasons["dead"] = "vault missing"
+	db.failures["failing"] = 2
+
+	// a run in progress keeps the dry run of the test vault away from the verifier
+	_, err := db.CreateFeeRun(context.Background(), feetypes.FeeRun{PublicKey: testPublicKey, Chain: "Ethereum"})
+	if err != nil {
+		t.Fatalf("CreateFeeRun: %v", err)
+	}
+
+	report, err := fp.DryRun(context.Background())
+	if err != nil {
+		t.Fatalf("DryRun: %v", err)
+	}
+
+	want := map[string]struct {
+		action string
+		reason string
+	}{
+		testPublicKey: {action: dryRunActionInProgress},
+		"leased":      {action: dryRunActionLeased, reason: "another replica"},
+		"review":      {action: string(planActionReview), reason: "debt above max fee amount"},
+		"dead":        {action: dryRunActionDeadLetter, reason: "vault missing"},
+		"failing":     {action: dryRunActionBackoff, reason: "2 failures"},
+	}
+	if len(report.Vaults) != len(want) {
+		t.Fatalf("%d vaults in the report, want %d", len(report.Vaults), len(want))
+	}
+	for _, v := range report.Vaults {
+		w, ok := want[v.PublicKey]
+		if !ok {
+			t.Fatalf("unexpected vault %s in the report", v.PublicKey)
+		}
+		if v.Action != w.action || !strings.Contains(v.Reason, w.reason) {
+			t.Errorf("vault %s: action %s (%s), want %s (%s)", v.PublicKey, v.Action, v.Reason, w.action, w.reason)
+		}
+	}
+
+	if owner, ok := db.leases[testPublicKey]; ok {
+		t.Errorf("lease of the test vault left to %s", owner)
+	}
+	if db.leases["leased"] != "another replica" {
+		t.Errorf("lease of another replica taken over")
+	}
+}
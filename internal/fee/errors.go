@@ -0,0 +1,83 @@
+package fee
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/vultisig/feeplugin/internal/metrics"
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+	"github.com/vultisig/feeplugin/internal/verifierapi"
+)
+
+// errorTypes groups the TransactionError codes into the types of the worker errors metric
+var errorTypes = map[string]string{
+	feetypes.TxErrorVerifierUnavailable:  metrics.ErrorTypeNetwork,
+	feetypes.TxErrorVerifierRejected:     metrics.ErrorTypeValidation,
+	feetypes.TxErrorVerifierUnauthorized: metrics.ErrorTypeValidation,
+	feetypes.TxErrorPublicKeyUnknown:     metrics.ErrorTypeValidation,
+	feetypes.TxErrorVaultMissing:         metrics.ErrorTypeValidation,
+	feetypes.TxErrorInsufficientBalance:  metrics.ErrorTypeValidation,
+	feetypes.TxErrorSimulationRevert:     metrics.ErrorTypeExecution,
+	feetypes.TxErrorKeysignTimeout:       metrics.ErrorTypeSigning,
+	feetypes.TxErrorSignatureMismatch:    metrics.ErrorTypeSigning,
+	feetypes.TxErrorBroadcastRejected:    metrics.ErrorTypeNetwork,
+	feetypes.TxErrorReceiptReverted:      metrics.ErrorTypeExecution,
+	feetypes.TxErrorInterrupted:          metrics.ErrorTypeExecution,
+	feetypes.TxErrorTxCancelled:          metrics.ErrorTypeNetwork,
+	feetypes.TxErrorTxDropped:            metrics.ErrorTypeNetwork,
+}
+
+// isTxError reports whether err is a TransactionError with the code
+func isTxError(err error, code string) bool {
+	return feetypes.TransactionErrorCode(err) == code
+}
+
+// verifierError types an error of the verifier api. A public key the verifier does not know and a
+// request it refuses with a client error are failures of the vault. The verifier refusing the token of the
+// plugin, failing with a server error, rate limiting the plugin or being unreachable affects every vault.
+func verifierError(err error) error {
+	if errors.Is(err, verifierapi.ErrPublicKeyNotFound) {
+		return feetypes.NewTransactionError(feetypes.TxErrorPublicKeyUnknown, err)
+	}
+
+	var statusErr *verifierapi.StatusError
+	if !errors.As(err, &statusErr) {
+		return feetypes.NewTransactionError(feetypes.TxErrorVerifierUnavailable, err)
+	}
+	switch code := statusErr.StatusCode; {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return feetypes.NewTransactionError(feetypes.TxErrorVerifierUnauthorized, err)
+	case code == http.StatusTooManyRequests || code >= http.StatusInternalServerError:
+		return feetypes.NewTransactionError(feetypes.TxErrorVerifierUnavailable, err)
+	case code >= http.StatusBadRequest:
+		return feetypes.NewTransactionError(feetypes.TxErrorVerifierRejected, err)
+	default:
+		return feetypes.NewTransactionError(feetypes.TxErrorVerifierUnavailable, err)
+	}
+}
+
+// keysignError types an error of the keysign session, it timed out when ctx ran out of time
+func keysignError(ctx context.Context, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return feetypes.NewTransactionError(feetypes.TxErrorKeysignTimeout, err)
+	}
+	return err
+}
+
+// recordTxError records a failure of the pipeline in the worker metrics, by its code when it is a
+// TransactionError
+func (fp *FeePlugin) recordTxError(err error) {
+	if fp.metrics == nil {
+		return
+	}
+
+	code := feetypes.TransactionErrorCode(err)
+	errorType, ok := errorTypes[code]
+	if !ok {
+		fp.metrics.RecordError(metrics.ErrorTypeExecution)
+		return
+	}
+	fp.metrics.RecordTransactionError(code)
+	fp.metrics.RecordError(errorType)
+}
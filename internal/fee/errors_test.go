@@ -0,0 +1,115 @@
+package fee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+	"github.com/vultisig/feeplugin/internal/verifierapi"
+)
+
+func TestVerifierError(t *testing.T) {
+	// answers the fees of a public key with the status code the public key is
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/fees/publickey/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(code)
+	}))
+	defer server.Close()
+	api := verifierapi.NewVerifierApi(server.URL, "token", testLogger())
+
+	tests := []struct {
+		status    int
+		code      string
+		permanent bool
+		shared    bool
+	}{
+		{status: http.StatusNotFound, code: feetypes.TxErrorPublicKeyUnknown},
+		{status: http.StatusBadRequest, code: feetypes.TxErrorVerifierRejected},
+		{status: http.StatusConflict, code: feetypes.TxErrorVerifierRejected},
+		{status: http.StatusUnauthorized, code: feetypes.TxErrorVerifierUnauthorized, shared: true},
+		{status: http.StatusForbidden, code: feetypes.TxErrorVerifierUnauthorized, shared: true},
+		{status: http.StatusTooManyRequests, code: feetypes.TxErrorVerifierUnavailable, shared: true},
+		{status: http.StatusInternalServerError, code: feetypes.TxErrorVerifierUnavailable, shared: true},
+		{status: http.StatusBadGateway, code: feetypes.TxErrorVerifierUnavailable, shared: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			_, err := api.GetPublicKeysFees(strconv.Itoa(tt.status))
+			if err == nil {
+				t.Fatalf("no error for status %d", tt.status)
+			}
+
+			err = verifierError(err)
+			if code := feetypes.TransactionErrorCode(err); code != tt.code {
+				t.Errorf("code = %q, want %q", code, tt.code)
+			}
+			if isPermanentFailure(err) != tt.permanent {
+				t.Errorf("permanent = %t, want %t", isPermanentFailure(err), tt.permanent)
+			}
+			if isSharedFailure(err) != tt.shared {
+				t.Errorf("shared = %t, want %t", isSharedFailure(err), tt.shared)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		unreachable.Close()
+
+		_, err := verifierapi.NewVerifierApi(unreachable.URL, "token", testLogger()).GetPublicKeysFees("pk")
+		err = verifierError(err)
+		if !isTxError(err, feetypes.TxErrorVerifierUnavailable) || !isSharedFailure(err) {
+			t.Errorf("err = %v, want a shared verifier_unavailable failure", err)
+		}
+	})
+}
+
+func TestRecordVaultFailure(t *testing.T) {
+	unknown := verifierError(verifierapi.ErrPublicKeyNotFound)
+	rejected := verifierError(&verifierapi.StatusError{StatusCode: http.StatusBadRequest})
+	unavailable := verifierError(&verifierapi.StatusError{StatusCode: http.StatusServiceUnavailable})
+	missing := feetypes.NewTransactionError(feetypes.TxErrorVaultMissing, errVaultNotFound)
+
+	tests := []struct {
+		name string
+		err  error
+		// failure after which the vault is dead-lettered, never when 0
+		deadLetterAt uint64
+	}{
+		{name: "public key unknown to the verifier", err: unknown, deadLetterAt: 3},
+		{name: "request rejected by the verifier", err: rejected, deadLetterAt: 3},
+		{name: "verifier unavailable", err: unavailable},
+		{name: "vault missing", err: missing, deadLetterAt: 1},
+		{name: "unknown tx type", err: fmt.Errorf("failed to aggregate fees: %w", errUnknownTxType), deadLetterAt: 1},
+		{name: "other failure", err: errors.New("boom"), deadLetterAt: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB()
+			fp := newTestFeePlugin(t, newFakeCollector(), db, newFakeVerifier(t))
+			fp.config.Retry.MaxAttempts = 3
+
+			for attempt := uint64(1); attempt <= 4; attempt++ {
+				fp.recordVaultFailure(context.Background(), testPublicKey, tt.err)
+
+				deadLettered := db.statuses[testPublicKey] == feetypes.PublicKeyStatusDeadLetter
+				want := tt.deadLetterAt != 0 && attempt >= tt.deadLetterAt
+				if deadLettered != want {
+					t.Fatalf("dead-lettered = %t after %d failures, want %t", deadLettered, attempt, want)
+				}
+			}
+		})
+	}
+}
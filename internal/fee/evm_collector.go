@@ -0,0 +1,579 @@
+package fee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/v2"
+	gcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+
+	v1 "github.com/vultisig/commondata/go/vultisig/vault/v1"
+	"github.com/vultisig/mobile-tss-lib/tss"
+	reth "github.com/vultisig/recipes/chain/evm/ethereum"
+	"github.com/vultisig/recipes/sdk/evm"
+	"github.com/vultisig/recipes/sdk/evm/codegen/erc20"
+	vtypes "github.com/vultisig/verifier/types"
+	"github.com/vultisig/vultisig-go/address"
+	"github.com/vultisig/vultisig-go/common"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+// evmRpc is the part of the Ethereum RPC used by the collector, evmrpc.Client implements it
+type evmRpc interface {
+	bind.ContractBackend
+	rawTxSender
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+	BalanceAt(ctx context.Context, account gcommon.Address, blockNumber *big.Int) (*big.Int, error)
+	PendingCallContract(ctx context.Context, msg ethereum.CallMsg) ([]byte, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+	TransactionReceipt(ctx context.Context, hash gcommon.Hash) (*types.Receipt, error)
+	TransactionByHash(ctx context.Context, hash gcommon.Hash) (*types.Transaction, bool, error)
+}
+
+// evmCollector collects fees on an EVM chain, with dynamic fee txs
+type evmCollector struct {
+	chain       common.Chain
+	chainID     *big.Int
+	config      ChainConfig
+	rpc         evmRpc
+	sdk         *evm.SDK
+	prices      PriceSource
+	broadcaster *broadcaster
+}
+
+func newEvmCollector(chain common.Chain, config ChainConfig, rpc evmRpc, priceMaxAge time.Duration) (*evmCollector, error) {
+	chainID, err := chain.EvmID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s EVM ID: %w", chain.String(), err)
+	}
+
+	var prices PriceSource
+	if config.PriceFeedAddress != "" {
+		prices, err = NewChainlinkPriceSource(rpc, config.PriceFeedAddress, priceMaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s price source: %w", chain.String(), err)
+		}
+	}
+
+	b, err := newBroadcaster(config, evmSender(rpc), func(endpoint string) (sendFunc, error) {
+		client, err := gethrpc.Dial(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return evmSender(rpcSender{client: client}), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up %s broadcast: %w", chain.String(), err)
+	}
+
+	return &evmCollector{
+		chain:       chain,
+		chainID:     chainID,
+		config:      config,
+		rpc:         rpc,
+		sdk:         evm.NewSDK(chainID, rpc, rpc),
+		prices:      prices,
+		broadcaster: b,
+	}, nil
+}
+
+func (c *evmCollector) Chain() common.Chain {
+	return c.chain
+}
+
+func (c *evmCollector) Config() ChainConfig {
+	return c.config
+}
+
+func (c *evmCollector) Prices() PriceSource {
+	return c.prices
+}
+
+func (c *evmCollector) Address(vault *v1.Vault) (string, error) {
+	addr, _, _, err := address.GetAddress(vault.PublicKeyEcdsa, vault.HexChainCode, c.chain)
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s address: %w", c.chain.String(), err)
+	}
+	return addr, nil
+}
+
+// SameAddress compares the addresses case insensitively, checksummed or not
+func (c *evmCollector) SameAddress(a, b string) bool {
+	if !gcommon.IsHexAddress(a) || !gcommon.IsHexAddress(b) {
+		return false
+	}
+	return gcommon.HexToAddress(a) == gcommon.HexToAddress(b)
+}
+
+func (c *evmCollector) Balance(ctx context.Context, addr, asset string) (*big.Int, error) {
+	if asset == NativeAsset {
+		balance, err := c.rpc.BalanceAt(ctx, gcommon.HexToAddress(addr), nil)
+		if err != nil {
+			return nil, fmt.Errorf("p.ethRpc.BalanceAt: %w", err)
+		}
+		return balance, nil
+	}
+
+	token := erc20.NewErc20()
+	return evm.CallReadonly(
+		ctx,
+		c.rpc,
+		token,
+		gcommon.HexToAddress(asset),
+		token.PackBalanceOf(gcommon.HexToAddress(addr)),
+		token.UnpackBalanceOf,
+		nil,
+	)
+}
+
+func (c *evmCollector) Allowance(ctx context.Context, token, owner, spender string) (*big.Int, error) {
+	contract := erc20.NewErc20()
+	return evm.CallReadonly(
+		ctx,
+		c.rpc,
+		contract,
+		gcommon.HexToAddress(token),
+		contract.PackAllowance(gcommon.HexToAddress(owner), gcommon.HexToAddress(spender)),
+		contract.UnpackAllowance,
+		nil,
+	)
+}
+
+// BuildTransfer builds a transfer of the asset contract, or of the native coin for NativeAsset
+func (c *evmCollector) BuildTransfer(ctx context.Context, from, to, asset string, amount *big.Int) ([]byte, error) {
+	// resolved from the pending state, so the fee tx goes after whatever the vault already has in the mempool
+	nonce, err := c.rpc.PendingNonceAt(ctx, gcommon.HexToAddress(from))
+	if err != nil {
+		return nil, fmt.Errorf("p.ethRpc.PendingNonceAt: %w", err)
+	}
+
+	tx, err := c.sdk.MakeAnyTransfer(
+		ctx,
+		gcommon.HexToAddress(from),
+		gcommon.HexToAddress(to),
+		gcommon.HexToAddress(asset),
+		amount,
+		0,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("p.eth.MakeAnyTransfer: %v", err)
+	}
+
+	// the sdk looks the nonce up again, pin the one resolved here as it is the one tracked on the attempt
+	return pinNonce(tx, nonce)
+}
+
+func (c *evmCollector) BuildCall(ctx context.Context, from, contract string, value *big.Int, data []byte) ([]byte, error) {
+	nonce, err := c.rpc.PendingNonceAt(ctx, gcommon.HexToAddress(from))
+	if err != nil {
+		return nil, fmt.Errorf("p.ethRpc.PendingNonceAt: %w", err)
+	}
+
+	tx, err := c.sdk.MakeTx(
+		ctx,
+		gcommon.HexToAddress(from),
+		gcommon.HexToAddress(contract),
+		value,
+		data,
+		0,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("p.eth.MakeTx: %w", err)
+	}
+	return pinNonce(tx, nonce)
+}
+
+func (c *evmCollector) TxInfo(tx []byte) (*TxInfo, error) {
+	unsigned, err := decodeDynamicFeeTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	return &TxInfo{
+		Nonce: unsigned.Nonce,
+		Value: unsigned.Value,
+		// worst case, the fee cap is paid for all the gas
+		Fee: new(big.Int).Mul(new(big.Int).SetUint64(unsigned.Gas), unsigned.GasFeeCap),
+	}, nil
+}
+
+// Simulate runs the tx with eth_call and estimates its gas, without its fee fields so the vault does
+// not need the gas budget of a whole block. A call which reverts fails with a simulation_revert
+// TransactionError.
+func (c *evmCollector) Simulate(ctx context.Context, from string, tx []byte) (uint64, error) {
+	unsigned, err := decodeDynamicFeeTx(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	msg := ethereum.CallMsg{
+		From:  gcommon.HexToAddress(from),
+		To:    unsigned.To,
+		Value: unsigned.Value,
+		Data:  unsigned.Data,
+	}
+	_, err = c.rpc.PendingCallContract(ctx, msg)
+	if err != nil {
+		return 0, simulationError(fmt.Errorf("p.ethRpc.PendingCallContract: %w", err))
+	}
+
+	gas, err := c.rpc.EstimateGas(ctx, msg)
+	if err != nil {
+		return 0, simulationError(fmt.Errorf("p.ethRpc.EstimateGas: %w", err))
+	}
+	return gas, nil
+}
+
+// evmRevertCode is the JSON-RPC error code nodes answer a reverted call with
+const evmRevertCode = 3
+
+// simulationError turns a revert the node answered a simulated call with into a simulation_revert
+// TransactionError, with the revert reason decoded when the contract gave one. Other errors, transport
+// ones or a node failing to serve the call, are returned as is and the vault is retried.
+func simulationError(err error) error {
+	var rpcErr gethrpc.Error
+	if !errors.As(err, &rpcErr) {
+		return err
+	}
+
+	var revertData []byte
+	var dataErr gethrpc.DataError
+	if errors.As(err, &dataErr) {
+		if data, ok := dataErr.ErrorData().(string); ok {
+			revertData = gcommon.FromHex(data)
+		}
+	}
+	reverted := rpcErr.ErrorCode() == evmRevertCode ||
+		len(revertData) > 0 ||
+		strings.Contains(strings.ToLower(rpcErr.Error()), "execution reverted")
+	if !reverted {
+		return err
+	}
+
+	message := rpcErr.Error()
+	reason, unpackErr := abi.UnpackRevert(revertData)
+	if unpackErr == nil {
+		message = "execution reverted: " + reason
+	}
+	return &feetypes.TransactionError{
+		Code:    feetypes.TxErrorSimulationRevert,
+		Message: message,
+		Err:     err,
+	}
+}
+
+// evmTxKnownErrors are what nodes answer a tx they already hold with, an earlier send went through
+var evmTxKnownErrors = []string{
+	"already known",
+	"known transaction",
+	"already imported",
+}
+
+// evmTxRejectedErrors are what nodes answer a tx they will never take as it is with
+var evmTxRejectedErrors = []string{
+	"nonce too low",
+	"insufficient funds",
+	"intrinsic gas too low",
+	"exceeds block gas limit",
+	"invalid sender",
+	"exceeds the configured cap",
+	"max priority fee per gas higher than max fee per gas",
+	"transaction type not supported",
+	"oversized data",
+	"invalid chain id",
+}
+
+// broadcastError classifies an error the node answered a sent tx with. A tx the node already holds is
+// not an error, a permanent rejection is a broadcast_rejected TransactionError. Anything else, an
+// underpriced replacement, a full mempool, a rate limit or a transport error, is returned as is: the tx
+// can be sent again.
+func broadcastError(err error) error {
+	var rpcErr gethrpc.Error
+	if !errors.As(err, &rpcErr) {
+		return err
+	}
+
+	message := strings.ToLower(rpcErr.Error())
+	for _, known := range evmTxKnownErrors {
+		if strings.Contains(message, known) {
+			return nil
+		}
+	}
+	for _, rejected := range evmTxRejectedErrors {
+		if strings.Contains(message, rejected) {
+			return &feetypes.TransactionError{
+				Code:    feetypes.TxErrorBroadcastRejected,
+				Message: rpcErr.Error(),
+				Err:     err,
+			}
+		}
+	}
+	return err
+}
+
+func (c *evmCollector) SignRequest(policy vtypes.PluginPolicy, txID string, tx []byte) (*vtypes.PluginKeysignRequest, error) {
+	req, err := vtypes.NewPluginKeysignRequestEvm(policy, txID, c.chain, tx)
+	if err != nil {
+		return nil, fmt.Errorf("vtypes.NewPluginKeysignRequestEvm: %w", err)
+	}
+	return req, nil
+}
+
+func (c *evmCollector) SignedTx(tx []byte, sigs map[string]tss.KeysignResponse) ([]byte, error) {
+	signed, err := SignedEvmTx(tx, sigs, c.chainID)
+	if err != nil {
+		return nil, err
+	}
+	return signed.MarshalBinary()
+}
+
+// Signer recovers the sender from the signature of the tx
+func (c *evmCollector) Signer(signed []byte) (string, error) {
+	tx := new(types.Transaction)
+	err := tx.UnmarshalBinary(signed)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode signed tx: %w", err)
+	}
+
+	sender, err := types.Sender(types.LatestSignerForChainID(c.chainID), tx)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errInvalidSignature, err)
+	}
+	return sender.Hex(), nil
+}
+
+func (c *evmCollector) TxHash(signed []byte) (string, error) {
+	tx := new(types.Transaction)
+	err := tx.UnmarshalBinary(signed)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode signed tx: %w", err)
+	}
+	return tx.Hash().Hex(), nil
+}
+
+// Broadcast sends the tx with the broadcast strategy of the chain
+func (c *evmCollector) Broadcast(ctx context.Context, signed []byte) (string, error) {
+	return c.broadcaster.broadcast(ctx, signed)
+}
+
+// rawTxSender sends signed txs, it returns the endpoint which accepted the tx when it picks among several.
+// evmrpc.Client implements it.
+type rawTxSender interface {
+	SendRawTransaction(ctx context.Context, signed []byte) (string, error)
+}
+
+// rpcSender sends signed txs through a single endpoint
+type rpcSender struct {
+	client *gethrpc.Client
+}
+
+func (s rpcSender) SendRawTransaction(ctx context.Context, signed []byte) (string, error) {
+	return "", s.client.CallContext(ctx, nil, "eth_sendRawTransaction", hexutil.Encode(signed))
+}
+
+// evmSender sends raw signed txs through the client
+func evmSender(client rawTxSender) sendFunc {
+	return func(ctx context.Context, signed []byte) (string, error) {
+		endpoint, err := client.SendRawTransaction(ctx, signed)
+		if err != nil {
+			// nil when the node already holds the tx
+			return "", broadcastError(fmt.Errorf("p.eth.Send(tx_hex=%s): %w", gcommon.Bytes2Hex(signed), err))
+		}
+		return endpoint, nil
+	}
+}
+
+func (c *evmCollector) Receipt(ctx context.Context, hash string) (*Receipt, error) {
+	receipt, err := c.rpc.TransactionReceipt(ctx, gcommon.HexToHash(hash))
+	if err != nil {
+		if !errors.Is(err, ethereum.NotFound) {
+			return nil, fmt.Errorf("failed to get tx receipt: %w", err)
+		}
+
+		_, _, err = c.rpc.TransactionByHash(ctx, gcommon.HexToHash(hash))
+		if err == nil {
+			return &Receipt{Status: ReceiptStatusPending}, nil
+		}
+		if !errors.Is(err, ethereum.NotFound) {
+			return nil, fmt.Errorf("failed to get tx: %w", err)
+		}
+		return &Receipt{Status: ReceiptStatusNotFound}, nil
+	}
+
+	latestBlock, err := c.rpc.BlockNumber(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest block number: %w", err)
+	}
+
+	res := &Receipt{Status: ReceiptStatusSuccess}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		res.Status = ReceiptStatusFailed
+	}
+	if latestBlock >= receipt.BlockNumber.Uint64() {
+		res.Confirmations = latestBlock - receipt.BlockNumber.Uint64() + 1
+	}
+	return res, nil
+}
+
+// GasPrices are estimated the same way the sdk prices the fee tx
+func (c *evmCollector) GasPrices(ctx context.Context) (*big.Int, *big.Int, error) {
+	tipCap, baseFee, err := c.networkFees(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	tipCap = bumpGas(tipCap, 50)
+	feeCap := new(big.Int).Add(tipCap, bumpGas(baseFee, 50))
+	return tipCap, feeCap, nil
+}
+
+func (c *evmCollector) GasPrice(ctx context.Context) (*big.Int, error) {
+	tipCap, baseFee, err := c.networkFees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Add(tipCap, baseFee), nil
+}
+
+// networkFees returns the suggested tip and the base fee of the latest block
+func (c *evmCollector) networkFees(ctx context.Context) (*big.Int, *big.Int, error) {
+	tipCap, err := c.rpc.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("p.ethRpc.SuggestGasTipCap: %w", err)
+	}
+	head, err := c.rpc.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("p.ethRpc.HeaderByNumber: %w", err)
+	}
+	if head.BaseFee == nil {
+		return nil, nil, fmt.Errorf("latest header has no base fee")
+	}
+	return tipCap, head.BaseFee, nil
+}
+
+func (c *evmCollector) SpeedUp(ctx context.Context, stuckTx []byte, percent uint64) ([]byte, error) {
+	tx, err := decodeDynamicFeeTx(stuckTx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.bumpFees(ctx, tx, percent)
+	if err != nil {
+		return nil, err
+	}
+	return encodeDynamicFeeTx(tx)
+}
+
+func (c *evmCollector) Cancel(ctx context.Context, from, to string, stuckTx []byte, percent uint64) ([]byte, error) {
+	tx, err := decodeDynamicFeeTx(stuckTx)
+	if err != nil {
+		return nil, err
+	}
+
+	toAddress := gcommon.HexToAddress(to)
+	gas, err := c.rpc.EstimateGas(ctx, ethereum.CallMsg{
+		From:  gcommon.HexToAddress(from),
+		To:    &toAddress,
+		Value: big.NewInt(0),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("p.ethRpc.EstimateGas: %w", err)
+	}
+
+	tx.To = &toAddress
+	tx.Value = big.NewInt(0)
+	tx.Data = nil
+	tx.AccessList = nil
+	tx.Gas = gas
+
+	err = c.bumpFees(ctx, tx, percent)
+	if err != nil {
+		return nil, err
+	}
+	return encodeDynamicFeeTx(tx)
+}
+
+// bumpFees raises both fee caps by percent, or to the current network fees if higher
+func (c *evmCollector) bumpFees(ctx context.Context, tx *types.DynamicFeeTx, percent uint64) error {
+	tipCap, err := c.rpc.SuggestGasTipCap(ctx)
+	if err != nil {
+		return fmt.Errorf("p.ethRpc.SuggestGasTipCap: %w", err)
+	}
+	head, err := c.rpc.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("p.ethRpc.HeaderByNumber: %w", err)
+	}
+	if head.BaseFee == nil {
+		return fmt.Errorf("latest header has no base fee")
+	}
+	feeCap := new(big.Int).Add(tipCap, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+
+	tx.GasTipCap = maxBig(bumpGas(tx.GasTipCap, percent), tipCap)
+	tx.GasFeeCap = maxBig(bumpGas(tx.GasFeeCap, percent), feeCap)
+	if tx.GasFeeCap.Cmp(tx.GasTipCap) < 0 {
+		tx.GasFeeCap = new(big.Int).Set(tx.GasTipCap)
+	}
+	return nil
+}
+
+func pinNonce(tx []byte, nonce uint64) ([]byte, error) {
+	unsigned, err := decodeDynamicFeeTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	unsigned.Nonce = nonce
+	return encodeDynamicFeeTx(unsigned)
+}
+
+func decodeDynamicFeeTx(unsigned []byte) (*types.DynamicFeeTx, error) {
+	decoded, err := reth.DecodeUnsignedPayload(unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("reth.DecodeUnsignedPayload: %w", err)
+	}
+	tx, ok := decoded.(*types.DynamicFeeTx)
+	if !ok {
+		return nil, fmt.Errorf("expected dynamic fee tx, got %T", decoded)
+	}
+	return tx, nil
+}
+
+func encodeDynamicFeeTx(tx *types.DynamicFeeTx) ([]byte, error) {
+	b, err := rlp.EncodeToBytes(reth.DynamicFeeTxWithoutSignature{
+		ChainID:    tx.ChainID,
+		Nonce:      tx.Nonce,
+		GasTipCap:  tx.GasTipCap,
+		GasFeeCap:  tx.GasFeeCap,
+		Gas:        tx.Gas,
+		To:         tx.To,
+		Value:      tx.Value,
+		Data:       tx.Data,
+		AccessList: tx.AccessList,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rlp.EncodeToBytes: %w", err)
+	}
+	return append([]byte{types.DynamicFeeTxType}, b...), nil
+}
+
+// bumpGas : in + in*percent/100
+func bumpGas(in *big.Int, percent uint64) *big.Int {
+	bump := new(big.Int).Div(new(big.Int).Mul(in, new(big.Int).SetUint64(percent)), big.NewInt(100))
+	return new(big.Int).Add(in, bump)
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return new(big.Int).Set(b)
+}
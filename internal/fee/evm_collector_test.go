@@ -0,0 +1,122 @@
+package fee
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+// testRpcError is an error a JSON-RPC node answers with
+type testRpcError struct {
+	code    int
+	message string
+	data    any
+}
+
+func (e testRpcError) Error() string  { return e.message }
+func (e testRpcError) ErrorCode() int { return e.code }
+func (e testRpcError) ErrorData() any { return e.data }
+
+func TestSimulationError(t *testing.T) {
+	// Error(string) "insufficient allowance"
+	revertData := "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000016" +
+		"696e73756666696369656e7420616c6c6f77616e636500000000000000000000"
+
+	tests := []struct {
+		name    string
+		err     error
+		revert  bool
+		message string
+	}{
+		{
+			name:    "revert with a reason",
+			err:     testRpcError{code: 3, message: "execution reverted", data: revertData},
+			revert:  true,
+			message: "execution reverted: insufficient allowance",
+		},
+		{
+			name:    "revert without data",
+			err:     testRpcError{code: -32000, message: "execution reverted"},
+			revert:  true,
+			message: "execution reverted",
+		},
+		{
+			name:   "revert code",
+			err:    testRpcError{code: 3, message: "reverted"},
+			revert: true,
+		},
+		{
+			name:   "revert data",
+			err:    testRpcError{code: -32015, message: "vm error", data: "0x1234"},
+			revert: true,
+		},
+		{
+			name: "rate limited",
+			err:  testRpcError{code: -32005, message: "request rate limit exceeded"},
+		},
+		{
+			name: "node failure",
+			err:  testRpcError{code: -32000, message: "header not found"},
+		},
+		{
+			name: "transport",
+			err:  errors.New("connection refused"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := simulationError(fmt.Errorf("p.ethRpc.EstimateGas: %w", tt.err))
+			if isTxError(err, feetypes.TxErrorSimulationRevert) != tt.revert {
+				t.Fatalf("err = %v, want a simulation revert %t", err, tt.revert)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, does not wrap the node error", err)
+			}
+			var txErr *feetypes.TransactionError
+			if tt.message != "" && errors.As(err, &txErr) && txErr.Message != tt.message {
+				t.Errorf("message = %q, want %q", txErr.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestBroadcastError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		accepted bool
+		rejected bool
+	}{
+		{name: "already known", err: testRpcError{code: -32000, message: "already known"}, accepted: true},
+		{name: "known transaction", err: testRpcError{code: -32000, message: "known transaction: 0xab"}, accepted: true},
+		{name: "nonce too low", err: testRpcError{code: -32000, message: "nonce too low: next nonce 8, tx nonce 7"}, rejected: true},
+		{name: "insufficient funds", err: testRpcError{code: -32000, message: "insufficient funds for gas * price + value"}, rejected: true},
+		{name: "underpriced replacement", err: testRpcError{code: -32000, message: "replacement transaction underpriced"}},
+		{name: "full mempool", err: testRpcError{code: -32000, message: "txpool is full"}},
+		{name: "rate limited", err: testRpcError{code: -32005, message: "request rate limit exceeded"}},
+		{name: "transport", err: errors.New("connection reset by peer")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := broadcastError(fmt.Errorf("p.eth.Send: %w", tt.err))
+			if tt.accepted {
+				if err != nil {
+					t.Fatalf("err = %v, want the tx accepted", err)
+				}
+				return
+			}
+			if isTxError(err, feetypes.TxErrorBroadcastRejected) != tt.rejected {
+				t.Fatalf("err = %v, want a broadcast rejection %t", err, tt.rejected)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, does not wrap the node error", err)
+			}
+		})
+	}
+}
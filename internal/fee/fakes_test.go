@@ -0,0 +1,616 @@
+package fee
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	v1 "github.com/vultisig/commondata/go/vultisig/vault/v1"
+	"github.com/vultisig/mobile-tss-lib/tss"
+	vstorage "github.com/vultisig/verifier/plugin/tx_indexer/pkg/storage"
+	vtypes "github.com/vultisig/verifier/types"
+	"github.com/vultisig/vultisig-go/common"
+
+	"github.com/vultisig/feeplugin/internal/storage"
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+	"github.com/vultisig/feeplugin/internal/verifierapi"
+)
+
+const (
+	testPublicKey       = "02a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	testVaultAddress    = "0x1111111111111111111111111111111111111111"
+	testTreasuryAddress = "0x2222222222222222222222222222222222222222"
+	testUsdcAddress     = "0x3333333333333333333333333333333333333333"
+	testVaultSecret     = "secret"
+)
+
+// fakeTx is the unsigned tx of a fakeCollector
+type fakeTx struct {
+	Nonce  uint64   `json:"nonce"`
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	Asset  string   `json:"asset"`
+	Amount *big.Int `json:"amount"`
+	Fee    *big.Int `json:"fee"`
+	Data   []byte   `json:"data,omitempty"`
+}
+
+func decodeFakeTx(t *testing.T, b64 string) fakeTx {
+	t.Helper()
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		t.Fatalf("failed to decode b64 tx: %v", err)
+	}
+	var tx fakeTx
+	err = json.Unmarshal(raw, &tx)
+	if err != nil {
+		t.Fatalf("failed to decode tx: %v", err)
+	}
+	return tx
+}
+
+// fakeCollector is an in-memory chain, its txs are JSON and the outcome of a tx is scripted by hash
+type fakeCollector struct {
+	chain      common.Chain
+	config     ChainConfig
+	nonce      uint64
+	balances   map[string]*big.Int // by asset
+	allowances map[string]*big.Int // by token, given to any spender
+	signer     string              // who signs the txs, the vault address when empty
+	receipts   map[string]*Receipt // by hash, unknown txs are not found
+	sent       [][]byte            // broadcasted signed txs
+	sendErr    error               // returned by Broadcast
+}
+
+func newFakeCollector() *fakeCollector {
+	return &fakeCollector{
+		chain: common.Ethereum,
+		config: ChainConfig{
+			UsdcAddress:     testUsdcAddress,
+			UsdcDecimals:    6,
+			TreasuryAddress: testTreasuryAddress,
+		},
+		nonce:    7,
+		balances: map[string]*big.Int{testUsdcAddress: big.NewInt(1000e6)},
+		receipts: map[string]*Receipt{},
+	}
+}
+
+func (c *fakeCollector) Chain() common.Chain { return c.chain }
+func (c *fakeCollector) Config() ChainConfig { return c.config }
+func (c *fakeCollector) Prices() PriceSource { return nil }
+
+func (c *fakeCollector) Address(vault *v1.Vault) (string, error) {
+	if vault.PublicKeyEcdsa != testPublicKey {
+		return "", fmt.Errorf("unexpected vault %s", vault.PublicKeyEcdsa)
+	}
+	return testVaultAddress, nil
+}
+
+func (c *fakeCollector) SameAddress(a, b string) bool {
+	return a == b
+}
+
+func (c *fakeCollector) Balance(_ context.Context, _, asset string) (*big.Int, error) {
+	if b, ok := c.balances[asset]; ok {
+		return b, nil
+	}
+	return new(big.Int), nil
+}
+
+func (c *fakeCollector) BuildTransfer(_ context.Context, from, to, asset string, amount *big.Int) ([]byte, error) {
+	return json.Marshal(fakeTx{Nonce: c.nonce, From: from, To: to, Asset: asset, Amount: amount, Fee: big.NewInt(100)})
+}
+
+func (c *fakeCollector) decode(tx []byte) (fakeTx, error) {
+	var f fakeTx
+	err := json.Unmarshal(tx, &f)
+	if err != nil {
+		return f, fmt.Errorf("failed to decode tx: %w", err)
+	}
+	return f, nil
+}
+
+func (c *fakeCollector) TxInfo(tx []byte) (*TxInfo, error) {
+	f, err := c.decode(tx)
+	if err != nil {
+		return nil, err
+	}
+	value := new(big.Int)
+	if f.Asset == NativeAsset {
+		value = f.Amount
+	}
+	return &TxInfo{Nonce: f.Nonce, Value: value, Fee: f.Fee}, nil
+}
+
+func (c *fakeCollector) SignRequest(policy vtypes.PluginPolicy, txID string, tx []byte) (*vtypes.PluginKeysignRequest, error) {
+	return &vtypes.PluginKeysignRequest{
+		KeysignRequest: vtypes.KeysignRequest{PublicKey: policy.PublicKey},
+		Transaction:    base64.StdEncoding.EncodeToString(tx),
+	}, nil
+}
+
+func (c *fakeCollector) SignedTx(tx []byte, sigs map[string]tss.KeysignResponse) ([]byte, error) {
+	for _, sig := range sigs {
+		return append(slices.Clone(tx), []byte("|"+sig.R+sig.S)...), nil
+	}
+	return nil, errors.New("no signature")
+}
+
+func (c *fakeCollector) Signer([]byte) (string, error) {
+	if c.signer != "" {
+		return c.signer, nil
+	}
+	return testVaultAddress, nil
+}
+
+func (c *fakeCollector) TxHash(signed []byte) (string, error) {
+	sum := sha256.Sum256(signed)
+	return "0x" + hex.EncodeToString(sum[:]), nil
+}
+
+func (c *fakeCollector) Broadcast(_ context.Context, signed []byte) (string, error) {
+	if c.sendErr != nil {
+		return "", c.sendErr
+	}
+	c.sent = append(c.sent, signed)
+	return "node.test", nil
+}
+
+func (c *fakeCollector) Receipt(_ context.Context, hash string) (*Receipt, error) {
+	if r, ok := c.receipts[hash]; ok {
+		return r, nil
+	}
+	return &Receipt{Status: ReceiptStatusNotFound}, nil
+}
+
+func (c *fakeCollector) SpeedUp(_ context.Context, tx []byte, percent uint64) ([]byte, error) {
+	f, err := c.decode(tx)
+	if err != nil {
+		return nil, err
+	}
+	f.Fee = bumpBy(f.Fee, percent)
+	return json.Marshal(f)
+}
+
+func (c *fakeCollector) Cancel(_ context.Context, from, to string, tx []byte, percent uint64) ([]byte, error) {
+	f, err := c.decode(tx)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fakeTx{
+		Nonce:  f.Nonce,
+		From:   from,
+		To:     to,
+		Asset:  NativeAsset,
+		Amount: new(big.Int),
+		Fee:    bumpBy(f.Fee, percent),
+	})
+}
+
+func (c *fakeCollector) Allowance(_ context.Context, token, _, _ string) (*big.Int, error) {
+	if a, ok := c.allowances[token]; ok {
+		return a, nil
+	}
+	return new(big.Int), nil
+}
+
+func (c *fakeCollector) BuildCall(_ context.Context, from, contract string, value *big.Int, data []byte) ([]byte, error) {
+	return json.Marshal(fakeTx{Nonce: c.nonce, From: from, To: contract, Asset: NativeAsset, Amount: value, Fee: big.NewInt(100), Data: data})
+}
+
+func bumpBy(n *big.Int, percent uint64) *big.Int {
+	bumped := new(big.Int).Mul(n, new(big.Int).SetUint64(100+percent))
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+// fakeDB keeps the fee runs and their attempts in memory, the methods the tests don't reach are not implemented
+type fakeDB struct {
+	storage.DatabaseStorage
+
+	runs     []*feetypes.FeeRun
+	attempts map[uuid.UUID][]feetypes.FeeRunAttempt
+	keys     []string                            // public keys of the installed vaults
+	failures map[string]uint64                   // consecutive failures by public key, failing vaults are backing off
+	statuses map[string]feetypes.PublicKeyStatus // by public key, active if not set
+	reasons  map[string]string                   // status reasons by public key
+	leases   map[string]string                   // lease owners by public key
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		attempts: map[uuid.UUID][]feetypes.FeeRunAttempt{},
+		failures: map[string]uint64{},
+		statuses: map[string]feetypes.PublicKeyStatus{},
+		reasons:  map[string]string{},
+		leases:   map[string]string{},
+	}
+}
+
+func (db *fakeDB) status(publicKey string) feetypes.PublicKeyStatus {
+	if status, ok := db.statuses[publicKey]; ok {
+		return status
+	}
+	return feetypes.PublicKeyStatusActive
+}
+
+func (db *fakeDB) GetPublicKeysByStatus(_ context.Context, status feetypes.PublicKeyStatus) ([]string, error) {
+	var pks []string
+	for _, pk := range db.keys {
+		if db.status(pk) == status && db.failures[pk] == 0 {
+			pks = append(pks, pk)
+		}
+	}
+	return pks, nil
+}
+
+func (db *fakeDB) GetHeldPublicKeys(context.Context) ([]*feetypes.PublicKey, error) {
+	var keys []*feetypes.PublicKey
+	for _, pk := range db.keys {
+		if db.status(pk) == feetypes.PublicKeyStatusActive && db.failures[pk] == 0 {
+			continue
+		}
+		key := &feetypes.PublicKey{PublicKey: pk, Status: db.status(pk), FailedAttempts: db.failures[pk]}
+		if reason, ok := db.reasons[pk]; ok {
+			key.StatusReason = &reason
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (db *fakeDB) AcquirePublicKeyLease(_ context.Context, publicKey, owner string, _ time.Duration) (bool, error) {
+	if current, ok := db.leases[publicKey]; ok && current != owner {
+		return false, nil
+	}
+	db.leases[publicKey] = owner
+	return true, nil
+}
+
+func (db *fakeDB) ReleasePublicKeyLease(_ context.Context, publicKey, owner string) error {
+	if db.leases[publicKey] == owner {
+		delete(db.leases, publicKey)
+	}
+	return nil
+}
+
+func (db *fakeDB) RecordPublicKeyFailure(_ context.Context, publicKey string, _ string, _, _ time.Duration) (uint64, error) {
+	db.failures[publicKey]++
+	return db.failures[publicKey], nil
+}
+
+func (db *fakeDB) ResetPublicKeyFailures(_ context.Context, publicKey string) error {
+	delete(db.failures, publicKey)
+	return nil
+}
+
+func (db *fakeDB) SetPublicKeyStatus(_ context.Context, publicKey string, status feetypes.PublicKeyStatus, reason string) error {
+	db.statuses[publicKey] = status
+	db.reasons[publicKey] = reason
+	return nil
+}
+
+func (db *fakeDB) run(id uuid.UUID) (*feetypes.FeeRun, error) {
+	for _, r := range db.runs {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("fee run %s not found", id)
+}
+
+func (db *fakeDB) setStatus(id uuid.UUID, status feetypes.FeeRunState, txHash *string) (*feetypes.FeeRun, error) {
+	r, err := db.run(id)
+	if err != nil {
+		return nil, err
+	}
+	r.Status = status
+	r.UpdatedAt = time.Now()
+	if txHash != nil {
+		hash := *txHash
+		r.TxHash = &hash
+	}
+	return r, nil
+}
+
+// age moves the run and its attempts back in time by d
+func (db *fakeDB) age(id uuid.UUID, d time.Duration) {
+	r, _ := db.run(id)
+	r.UpdatedAt = r.UpdatedAt.Add(-d)
+	for i := range db.attempts[id] {
+		db.attempts[id][i].CreatedAt = db.attempts[id][i].CreatedAt.Add(-d)
+	}
+}
+
+func (db *fakeDB) HasActiveFeeRun(_ context.Context, publicKey string) (bool, error) {
+	for _, r := range db.runs {
+		if r.PublicKey != publicKey {
+			continue
+		}
+		switch r.Status {
+		case feetypes.FeeRunStateDraft, feetypes.FeeRunStateSigned, feetypes.FeeRunStateSent:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (db *fakeDB) GetUnsentFeeRun(_ context.Context, publicKey string) (*feetypes.FeeRun, error) {
+	for _, r := range db.runs {
+		if r.PublicKey == publicKey && (r.Status == feetypes.FeeRunStateDraft || r.Status == feetypes.FeeRunStateSigned) {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *fakeDB) GetUnsettledFeeRuns(_ context.Context, publicKey string) ([]*feetypes.FeeRun, error) {
+	var runs []*feetypes.FeeRun
+	for _, r := range db.runs {
+		if r.PublicKey == publicKey && r.Status == feetypes.FeeRunStateSuccess && r.Partial && r.SettledAt == nil {
+			runs = append(runs, r)
+		}
+	}
+	return runs, nil
+}
+
+func (db *fakeDB) CreateFeeRun(_ context.Context, draft feetypes.FeeRun) (*feetypes.FeeRun, error) {
+	r := draft
+	r.ID = uuid.New()
+	r.Status = feetypes.FeeRunStateDraft
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = r.CreatedAt
+	r.FeeCount = len(draft.FeeIDs)
+	db.runs = append(db.runs, &r)
+
+	created := r
+	return &created, nil
+}
+
+func (db *fakeDB) SetFeeRunSigned(_ context.Context, id uuid.UUID, attempt feetypes.FeeRunAttempt) error {
+	_, err := db.setStatus(id, feetypes.FeeRunStateSigned, &attempt.TxHash)
+	if err != nil {
+		return err
+	}
+	db.addAttempt(id, attempt)
+	return nil
+}
+
+func (db *fakeDB) SetFeeRunSent(_ context.Context, id uuid.UUID, txHash string, endpoint string) error {
+	_, err := db.setStatus(id, feetypes.FeeRunStateSent, &txHash)
+	if err != nil {
+		return err
+	}
+	for i, a := range db.attempts[id] {
+		if a.TxHash == txHash && endpoint != "" {
+			db.attempts[id][i].Endpoint = endpoint
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) SetFeeRunCompleted(_ context.Context, id uuid.UUID, txHash string) error {
+	r, err := db.setStatus(id, feetypes.FeeRunStateSuccess, &txHash)
+	if err != nil {
+		return err
+	}
+	if r.Partial {
+		return nil
+	}
+	now := time.Now()
+	r.SettledAt = &now
+	for _, instalment := range db.runs {
+		if instalment.PublicKey == r.PublicKey && instalment.Partial && instalment.SettledAt == nil &&
+			instalment.Status == feetypes.FeeRunStateSuccess {
+			instalment.SettledAt = &now
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) SetFeeRunFailed(_ context.Context, id uuid.UUID, errorCode string, errorMessage string) error {
+	r, err := db.setStatus(id, feetypes.FeeRunStateFailed, nil)
+	if err != nil {
+		return err
+	}
+	r.ErrorMessage = &errorMessage
+	if errorCode != "" {
+		r.ErrorCode = &errorCode
+	}
+	return nil
+}
+
+func (db *fakeDB) GetFeeRunsByStatus(_ context.Context, status feetypes.FeeRunState) ([]*feetypes.FeeRun, error) {
+	var runs []*feetypes.FeeRun
+	for _, r := range db.runs {
+		if r.Status == status {
+			run := *r
+			runs = append(runs, &run)
+		}
+	}
+	return runs, nil
+}
+
+func (db *fakeDB) AddFeeRunAttempt(_ context.Context, id uuid.UUID, attempt feetypes.FeeRunAttempt) error {
+	_, err := db.setStatus(id, feetypes.FeeRunStateSent, &attempt.TxHash)
+	if err != nil {
+		return err
+	}
+	db.addAttempt(id, attempt)
+	return nil
+}
+
+func (db *fakeDB) SetFeeRunAttemptEndpoint(_ context.Context, id uuid.UUID, txHash string, endpoint string) error {
+	for i, a := range db.attempts[id] {
+		if a.TxHash == txHash {
+			db.attempts[id][i].Endpoint = endpoint
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) DeleteFeeRunAttempt(_ context.Context, id uuid.UUID, txHash string) error {
+	r, err := db.run(id)
+	if err != nil {
+		return err
+	}
+	db.attempts[id] = slices.DeleteFunc(db.attempts[id], func(a feetypes.FeeRunAttempt) bool {
+		return a.TxHash == txHash
+	})
+	if attempts := db.attempts[id]; r.TxHash != nil && *r.TxHash == txHash && len(attempts) > 0 {
+		hash := attempts[len(attempts)-1].TxHash
+		r.TxHash = &hash
+	}
+	return nil
+}
+
+func (db *fakeDB) addAttempt(id uuid.UUID, attempt feetypes.FeeRunAttempt) {
+	attempt.ID = uuid.New()
+	attempt.FeeRunID = id
+	attempt.CreatedAt = time.Now()
+	db.attempts[id] = append(db.attempts[id], attempt)
+}
+
+func (db *fakeDB) GetFeeRunAttempts(_ context.Context, id uuid.UUID) ([]feetypes.FeeRunAttempt, error) {
+	return slices.Clone(db.attempts[id]), nil
+}
+
+// fakeVaultStorage serves the encrypted backup of the test vault
+type fakeVaultStorage struct {
+	backups map[string][]byte
+}
+
+func newFakeVaultStorage(t *testing.T) *fakeVaultStorage {
+	t.Helper()
+	raw, err := proto.Marshal(&v1.Vault{Name: "test", PublicKeyEcdsa: testPublicKey})
+	if err != nil {
+		t.Fatalf("failed to marshal vault: %v", err)
+	}
+	encrypted, err := common.EncryptVault(testVaultSecret, raw)
+	if err != nil {
+		t.Fatalf("failed to encrypt vault: %v", err)
+	}
+	container, err := proto.Marshal(&v1.VaultContainer{
+		Version:     1,
+		Vault:       base64.StdEncoding.EncodeToString(encrypted),
+		IsEncrypted: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal vault container: %v", err)
+	}
+
+	name := common.GetVaultBackupFilename(testPublicKey, vtypes.PluginVultisigFees_feee.String())
+	return &fakeVaultStorage{backups: map[string][]byte{
+		name: []byte(base64.StdEncoding.EncodeToString(container)),
+	}}
+}
+
+func (s *fakeVaultStorage) GetVault(fileName string) ([]byte, error) {
+	return s.backups[fileName], nil
+}
+
+func (s *fakeVaultStorage) SaveVault(fileName string, content []byte) error {
+	s.backups[fileName] = content
+	return nil
+}
+
+func (s *fakeVaultStorage) Exist(fileName string) (bool, error) {
+	_, ok := s.backups[fileName]
+	return ok, nil
+}
+
+func (s *fakeVaultStorage) DeleteFile(fileName string) error {
+	delete(s.backups, fileName)
+	return nil
+}
+
+// fakeSigner signs every request with a single signature
+type fakeSigner struct {
+	requests []vtypes.PluginKeysignRequest
+}
+
+func (s *fakeSigner) Sign(_ context.Context, req vtypes.PluginKeysignRequest) (map[string]tss.KeysignResponse, error) {
+	s.requests = append(s.requests, req)
+	return map[string]tss.KeysignResponse{
+		"msg": {R: fmt.Sprintf("r%d", len(s.requests)), S: "s"},
+	}, nil
+}
+
+type fakeTxTracker struct{}
+
+func (fakeTxTracker) CreateTx(_ context.Context, req vstorage.CreateTxDto) (vstorage.Tx, error) {
+	return vstorage.Tx{ID: uuid.New(), FromPublicKey: req.FromPublicKey, ProposedTxHex: req.ProposedTxHex}, nil
+}
+
+// collectedFees is the body of a fees collected report to the verifier
+type collectedFees struct {
+	IDs     []uint64 `json:"ids"`
+	TxHash  string   `json:"tx_hash"`
+	Network string   `json:"network"`
+	Amount  uint64   `json:"amount"`
+}
+
+// fakeVerifier records the fees reported as collected
+type fakeVerifier struct {
+	server    *httptest.Server
+	collected []collectedFees
+}
+
+func newFakeVerifier(t *testing.T) *fakeVerifier {
+	t.Helper()
+	v := &fakeVerifier{}
+	v.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/fees/collected" {
+			http.NotFound(w, r)
+			return
+		}
+		var body collectedFees
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v.collected = append(v.collected, body)
+	}))
+	t.Cleanup(v.server.Close)
+	return v
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// newTestFeePlugin wires a FeePlugin to the fakes, with the default config
+func newTestFeePlugin(t *testing.T, c Collector, db *fakeDB, verifier *fakeVerifier) *FeePlugin {
+	t.Helper()
+	logger := testLogger()
+	return &FeePlugin{
+		logger:                logger,
+		vault:                 newFakeVaultStorage(t),
+		vaultEncryptionSecret: testVaultSecret,
+		txIndexerService:      fakeTxTracker{},
+		verifierApi:           verifierapi.NewVerifierApi(verifier.server.URL, "token", logger),
+		db:                    db,
+		config:                DefaultFeeConfig(),
+		instanceID:            uuid.New().String(),
+		collectors:            []Collector{c},
+		signer:                &fakeSigner{},
+	}
+}
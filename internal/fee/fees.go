@@ -3,48 +3,61 @@ package fee
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
-	gcommon "github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
 	"github.com/sirupsen/logrus"
 
 	v1 "github.com/vultisig/commondata/go/vultisig/vault/v1"
 	"github.com/vultisig/mobile-tss-lib/tss"
-	"github.com/vultisig/recipes/sdk/evm"
+	"github.com/vultisig/recipes/sdk/swap"
 	"github.com/vultisig/verifier/plugin/keysign"
 	"github.com/vultisig/verifier/plugin/tx_indexer"
 	vstorage "github.com/vultisig/verifier/plugin/tx_indexer/pkg/storage"
 	vtypes "github.com/vultisig/verifier/types"
 	"github.com/vultisig/verifier/vault"
-	"github.com/vultisig/vultisig-go/address"
 	"github.com/vultisig/vultisig-go/common"
 
+	"github.com/vultisig/feeplugin/internal/evmrpc"
 	"github.com/vultisig/feeplugin/internal/metrics"
 	"github.com/vultisig/feeplugin/internal/storage"
+	feetypes "github.com/vultisig/feeplugin/internal/types"
 	"github.com/vultisig/feeplugin/internal/verifierapi"
 )
 
+// keysigner runs the keysign session of a fee tx, a *keysign.Signer
+type keysigner interface {
+	Sign(ctx context.Context, req vtypes.PluginKeysignRequest) (map[string]tss.KeysignResponse, error)
+}
+
+// txTracker records the fee txs in the tx indexer before they are signed, a *tx_indexer.Service
+type txTracker interface {
+	CreateTx(ctx context.Context, req vstorage.CreateTxDto) (vstorage.Tx, error)
+}
+
 type FeePlugin struct {
 	logger                *logrus.Logger
 	vaultService          *vault.ManagementService
 	vault                 vault.Storage
 	vaultEncryptionSecret string
-	txIndexerService      *tx_indexer.Service
+	txIndexerService      txTracker
+	client                *asynq.Client
 	verifierApi           *verifierapi.VerifierApi
 	db                    storage.DatabaseStorage
 	config                *FeeConfig
 	encryptionSecret      string
-	processingInterval    time.Duration
+	instanceID            string // owner of the vault leases taken by this replica
 
-	eth    *evm.SDK
-	ethRpc *ethclient.Client
-	signer *keysign.Signer
+	collectors []Collector // in collection order
+	signer     keysigner
+	swaps      SwapAggregator
 
 	metrics *metrics.WorkerMetrics
 }
@@ -55,24 +68,29 @@ func NewFeePlugin(
 	vaultService *vault.ManagementService,
 	vault vault.Storage,
 	vaultSecret string,
-	ethRpc *ethclient.Client,
+	rpcs map[common.Chain]*evmrpc.Client,
 	signer *keysign.Signer,
 	txIndexerService *tx_indexer.Service,
+	client *asynq.Client,
 	db storage.DatabaseStorage,
-	verifierUrl string,
-	pi time.Duration) (*FeePlugin, error) {
+	verifierUrl string) (*FeePlugin, error) {
 	verifierApi := verifierapi.NewVerifierApi(
 		verifierUrl,
 		config.VerifierToken,
 		logger.WithField("pkg", "verifierapi").Logger,
 	)
-	var eth = new(evm.SDK)
-	if ethRpc != nil {
-		ethEvmChainID, err := common.Ethereum.EvmID()
+	var collectors []Collector
+	for _, chain := range config.EnabledChains() {
+		chainConfig, _ := config.ChainConfig(chain)
+		c, err := newCollector(chain, chainConfig, rpcs, config.PriceMaxAge)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get Ethereum EVM ID: %w", err)
+			return nil, fmt.Errorf("failed to create %s collector: %w", chain.String(), err)
 		}
-		eth = evm.NewSDK(ethEvmChainID, ethRpc, ethRpc.Client())
+		collectors = append(collectors, c)
+	}
+	var swaps SwapAggregator
+	if config.Swap.Enabled {
+		swaps = swap.NewOneInchProvider(config.Swap.ApiKey)
 	}
 	return &FeePlugin{
 		config:                config,
@@ -81,278 +99,659 @@ func NewFeePlugin(
 		vault:                 vault,
 		vaultEncryptionSecret: vaultSecret,
 		txIndexerService:      txIndexerService,
+		client:                client,
 		db:                    db,
 		verifierApi:           verifierApi,
-		processingInterval:    pi,
+		instanceID:            uuid.New().String(),
 
-		eth:    eth,
-		ethRpc: ethRpc,
-		signer: signer,
+		collectors: collectors,
+		signer:     signer,
+		swaps:      swaps,
 
 		metrics: metrics.NewWorkerMetrics(),
 	}, nil
 }
 
-func (fp *FeePlugin) Run(ctx context.Context) {
-	if fp.processingInterval == 0 {
-		fp.processingInterval = 1 * time.Minute
+// HandleTransact is the fees:transaction task handler. A task with a TransactPayload only collects
+// the fees of that vault.
+func (fp *FeePlugin) HandleTransact(ctx context.Context, t *asynq.Task) error {
+	if len(t.Payload()) == 0 {
+		return fp.withJobLock(ctx, lockTransact, fp.ProcessFees)
 	}
-	ticker := time.NewTicker(fp.processingInterval)
-	defer ticker.Stop()
 
-	for {
-		select {
-		case <-ticker.C:
-			err := fp.ProcessFees(ctx)
-			if err != nil {
-				fp.logger.WithError(err).Error("failed to process fees")
-			}
-		case <-ctx.Done():
-			return
-		}
+	var payload TransactPayload
+	err := json.Unmarshal(t.Payload(), &payload)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal transact payload: %w", err)
 	}
+	_, err = fp.processVault(ctx, payload.PublicKey)
+	return err
 }
 
+// ProcessFees collects the pending fees previously loaded into the db. Vaults are processed concurrently,
+// at most Jobs.Transact.MaxConcurrentJobs at a time, each within Jobs.Transact.VaultTimeout.
 func (fp *FeePlugin) ProcessFees(ctx context.Context) error {
-	pks, err := fp.db.GetPublicKeys(ctx)
+	pks, err := fp.db.GetPublicKeysByStatus(ctx, feetypes.PublicKeyStatusActive)
 	if err != nil {
 		return fmt.Errorf("failed to get public keys: %w", err)
 	}
 	fp.logger.WithFields(logrus.Fields{
 		"pks": len(pks),
-	}).Info("requesting fees info")
+	}).Info("processing pending fees")
 	startTime := time.Now()
+	if fp.metrics != nil {
+		fp.metrics.ResetBelowThresholdDebt()
+	}
+
+	workers := fp.config.Jobs.Transact.MaxConcurrentJobs
+	if workers == 0 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
 	var count atomic.Int64
 	for _, pk := range pks {
-		fees, err := fp.verifierApi.GetPublicKeysFees(pk)
-		if err != nil {
-			return fmt.Errorf("failed to get fees: %w", err)
+		// vaults not started yet are left to the next run, those in flight are waited for
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
 		}
-
-		if len(fees) == 0 {
-			continue
+		if ctx.Err() != nil {
+			break
 		}
 
-		fp.logger.WithFields(logrus.Fields{
-			"pubkey": pk,
-		}).Info("processing fee")
+		wg.Add(1)
+		go func(pk string) {
+			defer wg.Done()
+			defer func() { <-sem }()
 
-		err = fp.executeFeesTransaction(ctx, pk, fees)
-		if err != nil {
-			fp.logger.WithError(err).Error("failed to process fee transaction")
-			continue
-		}
-		count.Add(1)
+			processed, err := fp.processVault(ctx, pk)
+			if err != nil {
+				fp.logger.WithError(err).WithField("pubkey", pk).Error("failed to process fee transaction")
+				return
+			}
+			if processed {
+				count.Add(1)
+			}
+		}(pk)
 	}
+	wg.Wait()
 
 	fp.logger.Info("processed fees: ", count.Load())
 	if fp.metrics != nil {
 		fp.metrics.RecordFeeExecution(time.Since(startTime))
 	}
 
-	return nil
+	return ctx.Err()
 }
 
-func (fp *FeePlugin) executeFeesTransaction(ctx context.Context, publickey string, fees []*vtypes.Fee) error {
-	startTime := time.Now()
-	if len(fees) == 0 {
-		return nil
+// processVault runs processPublicKey within Jobs.Transact.VaultTimeout, so a stuck keysign session does not
+// hold a worker for the rest of the run. Failures back the vault off, see recordVaultFailure. The vault
+// is leased for the duration, a vault another replica is processing is skipped.
+func (fp *FeePlugin) processVault(ctx context.Context, pk string) (bool, error) {
+	release, leased, err := fp.leaseVault(ctx, pk)
+	if err != nil {
+		return false, fmt.Errorf("failed to lease vault: %w", err)
+	}
+	if !leased {
+		fp.logger.WithField("pubkey", pk).Info("vault leased by another replica, skipping")
+		return false, nil
 	}
+	defer release()
 
-	vault, err := getVaultForPubKey(fp.vault, publickey, fp.vaultEncryptionSecret)
+	vaultCtx := ctx
+	if fp.config.Jobs.Transact.VaultTimeout > 0 {
+		var cancel context.CancelFunc
+		vaultCtx, cancel = context.WithTimeout(ctx, fp.config.Jobs.Transact.VaultTimeout)
+		defer cancel()
+	}
+
+	processed, err := fp.processPublicKey(vaultCtx, pk)
+	switch {
+	case err == nil:
+		fp.clearVaultFailures(ctx, pk)
+	case ctx.Err() == nil:
+		// not the vault's fault when the whole run is cancelled
+		fp.recordVaultFailure(ctx, pk, err)
+	}
+	return processed, err
+}
+
+// processPublicKey collects the pending fees of a single vault, it reports whether there were any
+func (fp *FeePlugin) processPublicKey(ctx context.Context, pk string) (bool, error) {
+	// the fees of a run left unsent by a stopped worker are not pending, the run is finished first
+	resumed, err := fp.resumeFeeRun(ctx, pk)
 	if err != nil {
-		return fmt.Errorf("failed to get vault: %w", err)
+		return false, err
+	}
+	if resumed {
+		return true, nil
 	}
 
-	ethAddress, _, _, err := address.GetAddress(vault.PublicKeyEcdsa, vault.HexChainCode, common.Ethereum)
+	fees, err := fp.db.GetPendingFees(ctx, pk)
 	if err != nil {
-		return fmt.Errorf("failed to get eth address: %w", err)
+		return false, fmt.Errorf("failed to get pending fees: %w", err)
+	}
+
+	if len(fees) == 0 {
+		return false, nil
 	}
 
-	chain := common.Ethereum
+	fp.logger.WithFields(logrus.Fields{
+		"pubkey": pk,
+	}).Info("processing fee")
 
-	var debt int64
-	var feeIds []uint64
-	for _, fee := range fees {
-		feeIds = append(feeIds, fee.ID)
-		switch fee.TxType {
-		case vtypes.TxTypeCredit:
-			debt -= int64(fee.Amount)
-		case vtypes.TxTypeDebit:
-			debt += int64(fee.Amount)
-		}
+	err = fp.executeFeesTransaction(ctx, pk, fees)
+	if err != nil {
+		return false, err
 	}
+	return true, nil
+}
 
-	if debt <= 0 {
+func (fp *FeePlugin) executeFeesTransaction(ctx context.Context, publickey string, fees []feetypes.Fee) error {
+	startTime := time.Now()
+	if len(fees) == 0 {
+		return nil
+	}
+
+	// a single run per vault at a time, the next one is created once the current tx is settled
+	active, err := fp.db.HasActiveFeeRun(ctx, publickey)
+	if err != nil {
+		return fmt.Errorf("failed to check active fee runs: %w", err)
+	}
+	if active {
 		fp.logger.WithFields(logrus.Fields{
 			"pubkey": publickey,
+		}).Info("fee run in progress, skipping")
+		return nil
+	}
+
+	plan, err := fp.planCollection(ctx, publickey, fees)
+	if err != nil {
+		return err
+	}
+
+	switch plan.action {
+	case planActionNone:
+		fp.logger.WithFields(logrus.Fields{
+			"pubkey":  publickey,
+			"debits":  plan.breakdown.Debits.String(),
+			"credits": plan.breakdown.Credits.String(),
 		}).Info("nothing to process, debt is negative or zero")
 		return nil
+	case planActionSettle:
+		return fp.settleInstalments(ctx, publickey, plan.instalments, plan.amount, plan.feeIds)
+	case planActionReview:
+		return fp.flagForReview(ctx, publickey, plan.chain.Chain(), plan.amount, plan.feeIds)
+	case planActionInsufficientFunds:
+		return fp.skipInsufficientFunds(ctx, publickey, plan.chain.Chain(), plan.amount, plan.balance, plan.feeIds)
+	case planActionDefer:
+		return fp.deferCollection(publickey, plan.reason)
+	case planActionBelowThreshold:
+		return fp.skipBelowThreshold(ctx, publickey, plan.chain.Chain(), plan.amount, plan.feeIds, plan.reason, plan.message)
 	}
 
-	amount := uint64(debt)
+	c := plan.collection
+	chain := c.chain.Chain()
+	batchKey := feeBatchKey(plan.feeIds)
+	run, err := fp.db.CreateFeeRun(ctx, feetypes.FeeRun{
+		PublicKey:   publickey,
+		Chain:       chain.String(),
+		TotalAmount: plan.amount,
+		Method:      c.method,
+		Asset:       c.asset,
+		AssetAmount: c.amount.String(),
+		Partial:     plan.partial,
+		FeeIDs:      plan.feeIds,
+		BatchKey:    &batchKey,
+		Debits:      plan.breakdown.Debits.String(),
+		Credits:     plan.breakdown.Credits.String(),
+		Net:         plan.breakdown.Net.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fee run: %w", err)
+	}
 
-	tx, e := fp.genUnsignedTx(
-		ctx,
-		ethAddress,
-		fp.config.TreasuryAddress,
-		fp.config.UsdcAddress,
-		new(big.Int).SetUint64(amount),
-	)
+	err = fp.sendFeesTransaction(ctx, c.chain, run, plan.address, plan.tx)
+	if err != nil {
+		return err
+	}
+
+	if fp.metrics != nil {
+		fp.metrics.RecordTransactionProcessing(chain.String(), metrics.OperationFeeSend, time.Since(startTime))
+	}
+
+	return nil
+}
+
+// collection is how a fee run pays its debt
+type collection struct {
+	chain  Collector
+	method feetypes.FeeRunMethod
+	asset  string   // NativeAsset for the native coin
+	amount *big.Int // in base units of the asset
+	tx     []byte   // unsigned tx, built with the collection when it needs a quote
+}
+
+// usdcCollection returns a USDC transfer on the first chain where the vault holds the whole debt. If there
+// is none it returns nil along with the chain holding the most USDC, and that balance.
+func (fp *FeePlugin) usdcCollection(
+	ctx context.Context,
+	addresses map[common.Chain]string,
+	amount uint64,
+) (*collection, Collector, *big.Int, error) {
+	var best Collector
+	var bestBalance *big.Int
+	for _, c := range fp.collectors {
+		usdc, err := c.Balance(ctx, addresses[c.Chain()], c.Config().UsdcAddress)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to get %s usdc balance: %w", c.Chain().String(), err)
+		}
+
+		want := usdcAmount(c, amount)
+		if usdc.Cmp(want) >= 0 {
+			return &collection{
+				chain:  c,
+				method: feetypes.FeeRunMethodTransfer,
+				asset:  c.Config().UsdcAddress,
+				amount: want,
+			}, c, usdc, nil
+		}
+		if best == nil || debtAmount(c, usdc) > debtAmount(best, bestBalance) {
+			best, bestBalance = c, usdc
+		}
+	}
+	return nil, best, bestBalance, nil
+}
+
+// fallbackCollection collects the debt in another asset when the vault does not hold enough USDC on any
+// chain: in the native coin if Config.NativeFallback is set, else by swapping one of Config.Swap.Assets.
+// Chains are tried in collection order, it returns nil if no chain can pay either way.
+func (fp *FeePlugin) fallbackCollection(
+	ctx context.Context,
+	publickey string,
+	addresses map[common.Chain]string,
+	amount uint64,
+) (*collection, error) {
+	for _, c := range fp.collectors {
+		address := addresses[c.Chain()]
+		if fp.config.NativeFallback && c.Prices() != nil {
+			wei, enough, err := fp.nativeFeeAmount(ctx, c, address, amount)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check %s native balance: %w", c.Chain().String(), err)
+			}
+			if enough {
+				fp.logger.WithFields(logrus.Fields{
+					"pubkey": publickey,
+					"chain":  c.Chain().String(),
+					"debt":   amount,
+					"wei":    wei.String(),
+				}).Info("usdc balance below debt, collecting in the native coin")
+				return &collection{
+					chain:  c,
+					method: feetypes.FeeRunMethodTransfer,
+					asset:  NativeAsset,
+					amount: wei,
+				}, nil
+			}
+		}
+
+		// the policy only declares the swap rule on Ethereum
+		if fp.swaps != nil && c.Chain() == common.Ethereum {
+			col, err := fp.swapCollection(ctx, c, publickey, address, amount)
+			if err != nil || col != nil {
+				return col, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// settleInstalments reports the fees as collected when the confirmed instalments already cover their debt
+func (fp *FeePlugin) settleInstalments(
+	ctx context.Context,
+	publickey string,
+	instalments []*feetypes.FeeRun,
+	paid uint64,
+	feeIds []uint64,
+) error {
+	last := instalments[len(instalments)-1]
+	if last.TxHash == nil {
+		return fmt.Errorf("fee run %s has no tx hash", last.ID)
+	}
+
+	err := fp.verifierApi.MarkFeeAsCollected(paid, *last.TxHash, last.Chain, feeIds...)
+	if err != nil {
+		return fmt.Errorf("failed to mark fee as collected: %w", verifierError(err))
+	}
+
+	err = fp.db.SettleFeeRuns(ctx, publickey, feeIds)
+	if err != nil {
+		return fmt.Errorf("failed to settle fee runs: %w", err)
+	}
+
+	fp.logger.WithFields(logrus.Fields{
+		"pubkey": publickey,
+		"amount": paid,
+	}).Info("fees settled by instalments")
+	return nil
+}
+
+// flagForReview records the debt above the cap as a skipped run and takes the vault out of collection
+func (fp *FeePlugin) flagForReview(
+	ctx context.Context,
+	publickey string,
+	chain common.Chain,
+	amount uint64,
+	feeIds []uint64,
+) error {
+	reason := fmt.Sprintf("debt %d is above max fee amount %d", amount, fp.config.MaxFeeAmount)
+
+	_, err := fp.db.CreateSkippedFeeRun(ctx, publickey, chain, amount, feeIds, "", reason)
+	if err != nil {
+		return fmt.Errorf("failed to create skipped fee run: %w", err)
+	}
+
+	err = fp.db.SetPublicKeyStatus(ctx, publickey, feetypes.PublicKeyStatusReview, reason)
+	if err != nil {
+		return fmt.Errorf("failed to flag public key for review: %w", err)
+	}
+
+	fp.logger.WithFields(logrus.Fields{
+		"pubkey": publickey,
+		"debt":   amount,
+	}).Warn("debt above max fee amount, vault flagged for review")
+	return nil
+}
+
+// skipInsufficientFunds records the vault as unable to pay, the fees stay pending for the next run
+func (fp *FeePlugin) skipInsufficientFunds(
+	ctx context.Context,
+	publickey string,
+	chain common.Chain,
+	amount uint64,
+	balance *big.Int,
+	feeIds []uint64,
+) error {
+	reason := fmt.Sprintf("insufficient funds: %s usdc balance %s, debt %d", chain.String(), balance.String(), amount)
+
+	_, err := fp.db.CreateSkippedFeeRun(ctx, publickey, chain, amount, feeIds, feetypes.TxErrorInsufficientBalance, reason)
+	if err != nil {
+		return fmt.Errorf("failed to create skipped fee run: %w", err)
+	}
+	fp.recordTxError(&feetypes.TransactionError{Code: feetypes.TxErrorInsufficientBalance, Message: reason})
+
+	fp.logger.WithFields(logrus.Fields{
+		"pubkey":  publickey,
+		"debt":    amount,
+		"balance": balance.String(),
+	}).Warn("insufficient usdc balance, skipping")
+	return nil
+}
+
+// sendFeesTransaction signs and broadcasts the tx of a draft run. The signed tx is stored before it is
+// broadcasted: once signed, the run is only resolved on chain, a worker stopping in between or a failed
+// broadcast leaves it for resumeFeeRun rather than releasing its fees to a second transfer. Only a tx the
+// node rejected fails the run.
+func (fp *FeePlugin) sendFeesTransaction(
+	ctx context.Context,
+	c Collector,
+	run *feetypes.FeeRun,
+	address string,
+	tx []byte,
+) error {
+	// last line of defence, never sign a transfer above the cap whatever the caller computed
+	if run.TotalAmount > fp.config.MaxFeeAmount {
+		err := fmt.Errorf("amount %d is above max fee amount %d", run.TotalAmount, fp.config.MaxFeeAmount)
+		fp.failFeeRun(ctx, run.ID, err)
+		return err
+	}
+
+	// a keysign session is the costly part, a tx the node already rejects is never signed
+	err := fp.preflight(ctx, c, run, address, tx)
+	if err != nil {
+		fp.failFeeRun(ctx, run.ID, err)
+		return err
+	}
+
+	attempt, signed, err := fp.signFeeTx(ctx, c, run.PublicKey, address, feetypes.FeeRunAttemptOriginal, tx)
+	if err == nil {
+		err = fp.db.SetFeeRunSigned(ctx, run.ID, *attempt)
+	}
+	if err != nil {
+		fp.recordFeeTx(c, run, false)
+		fp.failFeeRun(ctx, run.ID, err)
+		return err
+	}
+
+	endpoint, err := fp.broadcastFeeTx(ctx, c, run.PublicKey, attempt.TxHash, signed)
+	fp.recordFeeTx(c, run, err == nil)
+	if isTxError(err, feetypes.TxErrorBroadcastRejected) {
+		// the node answered, the tx is not in its mempool and can't be mined
+		fp.failFeeRun(ctx, run.ID, err)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	err = fp.db.SetFeeRunSent(ctx, run.ID, attempt.TxHash, endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to set fee run %s as sent: %w", run.ID, err)
+	}
+	return nil
+}
+
+// signFeeTx tracks and signs an unsigned fee tx of the vault on the chain, it returns the attempt along
+// with the signed tx
+func (fp *FeePlugin) signFeeTx(
+	ctx context.Context,
+	c Collector,
+	publickey string,
+	address string,
+	kind feetypes.FeeRunAttemptKind,
+	tx []byte,
+) (*feetypes.FeeRunAttempt, []byte, error) {
+	info, e := c.TxInfo(tx)
 	if e != nil {
-		return fmt.Errorf("p.genUnsignedTx: %w", e)
+		return nil, nil, e
 	}
 
 	txHex := base64.StdEncoding.EncodeToString(tx)
 
 	txToTrack, e := fp.txIndexerService.CreateTx(ctx, vstorage.CreateTxDto{
 		PluginID:      vtypes.PluginVultisigFees_feee,
-		ChainID:       chain,
+		ChainID:       c.Chain(),
 		FromPublicKey: publickey,
-		ToPublicKey:   ethAddress,
+		ToPublicKey:   address,
 		ProposedTxHex: txHex,
 	})
 	if e != nil {
-		return fmt.Errorf("p.txIndexerService.CreateTx: %w", e)
+		return nil, nil, fmt.Errorf("p.txIndexerService.CreateTx: %w", e)
 	}
 
-	signRequest, e := vtypes.NewPluginKeysignRequestEvm(
+	signRequest, e := c.SignRequest(
 		vtypes.PluginPolicy{
 			PluginID:  vtypes.PluginVultisigFees_feee,
 			PublicKey: publickey,
-		}, txToTrack.ID.String(), chain, tx)
-
-	err = fp.initSign(ctx, signRequest, amount, false, feeIds...)
-	success := err == nil
-	if fp.metrics != nil {
-		fp.metrics.RecordSendTransaction(fp.config.TreasuryAddress, common.Ethereum.String(), success)
+		}, txToTrack.ID.String(), tx)
+	if e != nil {
+		return nil, nil, fmt.Errorf("failed to create sign request: %w", e)
 	}
 
+	signed, txHash, err := fp.initSign(ctx, c, signRequest)
+	if err == nil {
+		err = verifySigner(c, signed, address)
+	}
 	if err != nil {
 		fp.logger.WithError(err).Error("failed to initSign")
-		if fp.metrics != nil {
-			fp.metrics.RecordError(metrics.ErrorTypeExecution)
-		}
-		return err
+		return nil, nil, err
 	}
 
-	if fp.metrics != nil {
-		fp.metrics.RecordTransactionProcessing(common.Ethereum.String(), metrics.OperationFeeSend, time.Since(startTime))
+	return &feetypes.FeeRunAttempt{
+		Kind:       kind,
+		Nonce:      info.Nonce,
+		TxHash:     txHash,
+		UnsignedTx: txHex,
+		SignedTx:   base64.StdEncoding.EncodeToString(signed),
+	}, signed, nil
+}
+
+// recordFeeTx records a signed fee tx of the run in the send or swap metrics
+func (fp *FeePlugin) recordFeeTx(c Collector, run *feetypes.FeeRun, success bool) {
+	if fp.metrics == nil {
+		return
 	}
+	switch run.Method {
+	case feetypes.FeeRunMethodSwap:
+		fp.metrics.RecordSwapTransactionWithFallback(run.Asset, c.Config().UsdcAddress, run.Chain, run.Chain, success)
+	default:
+		fp.metrics.RecordSendTransaction(assetLabel(run.Asset, c.Chain()), run.Chain, success)
+	}
+}
 
-	return nil
+// failFeeRun moves the fee run to the failed state, the original error is what the caller returns
+func (fp *FeePlugin) failFeeRun(ctx context.Context, runID uuid.UUID, cause error) {
+	var code string
+	var txErr *feetypes.TransactionError
+	if errors.As(cause, &txErr) {
+		code = txErr.Code
+	}
+
+	// recorded even when the vault ran out of time, else the run would keep the vault blocked
+	err := fp.db.SetFeeRunFailed(context.WithoutCancel(ctx), runID, code, cause.Error())
+	if err != nil {
+		fp.logger.WithError(err).WithField("fee_run_id", runID).Error("failed to set fee run as failed")
+	}
 }
 
+// initSign runs the keysign of the request, it returns the signed tx and its hash
 func (fp *FeePlugin) initSign(
 	ctx context.Context,
+	c Collector,
 	req *vtypes.PluginKeysignRequest,
-	amount uint64,
-	waitMined bool,
-	feeId ...uint64,
-) error {
+) ([]byte, string, error) {
 	if req == nil {
-		return fmt.Errorf("req is nil")
+		return nil, "", fmt.Errorf("req is nil")
 	}
 	sigs, err := fp.signer.Sign(ctx, *req)
 	if err != nil {
 		fp.logger.WithError(err).Error("Keysign failed")
-		return fmt.Errorf("failed to sign transaction: %w", err)
+		return nil, "", fmt.Errorf("failed to sign transaction: %w", keysignError(ctx, err))
 	}
 
 	if len(sigs) != 1 {
 		fp.logger.
 			WithField("sigs_count", len(sigs)).
-			Error("expected only 1 message+sig per request for evm")
-		return fmt.Errorf("failed to sign transaction: invalid signature count: %d", len(sigs))
-	}
-	var sig tss.KeysignResponse
-	for _, s := range sigs {
-		sig = s
+			Error("expected only 1 message+sig per request")
+		return nil, "", fmt.Errorf("failed to sign transaction: invalid signature count: %d", len(sigs))
 	}
 
 	txBytes, err := base64.StdEncoding.DecodeString(req.Transaction)
 	if err != nil {
-		return fmt.Errorf("failed to decode b64 proposed tx: %w", err)
+		return nil, "", fmt.Errorf("failed to decode b64 proposed tx: %w", err)
 	}
-	ethEvmChainID, err := common.Ethereum.EvmID()
+	signed, err := c.SignedTx(txBytes, sigs)
 	if err != nil {
-		return fmt.Errorf("failed to get Ethereum EVM ID: %w", err)
+		return nil, "", fmt.Errorf("failed to assemble signed tx: %w", err)
 	}
-	txHash, err := ComputeTxHash(txBytes, sigs, ethEvmChainID)
+	txHash, err := c.TxHash(signed)
 	if err != nil {
-		return fmt.Errorf("client.ComputeTxHash: %w", err)
+		return nil, "", fmt.Errorf("failed to compute tx hash: %w", err)
 	}
+	return signed, txHash, nil
+}
 
-	err = fp.verifierApi.MarkFeeAsCollected(amount, txHash, common.Ethereum.String(), feeId...)
-	if err != nil {
-		return fmt.Errorf("failed to mark fee as collected: %w", err)
+// verifySigner checks the signed tx was signed by the key of the vault address, a bad signature is caught
+// before the tx is stored or broadcasted
+func verifySigner(c Collector, signed []byte, address string) error {
+	signer, err := c.Signer(signed)
+	if errors.Is(err, errInvalidSignature) {
+		return &feetypes.TransactionError{
+			Code:    feetypes.TxErrorSignatureMismatch,
+			Message: err.Error(),
+			Err:     err,
+		}
 	}
-
-	tx, err := fp.broadcast(ctx, txBytes, sig, *req)
 	if err != nil {
-		fp.logger.WithError(err).Error("failed to complete signing process (broadcast tx)")
-		return fmt.Errorf("failed to complete signing process: %w", err)
+		return fmt.Errorf("failed to recover signer: %w", err)
 	}
 
-	if waitMined {
-		fp.logger.Println("waiting for tx being mined")
-		receipt, err := bind.WaitMined(ctx, fp.ethRpc, tx)
-		if err != nil {
-			fp.logger.WithError(err).Error("failed to wait tx being mined")
-			return fmt.Errorf("failed to wait tx being mined: %w", err)
-		}
-		if receipt.Status != types.ReceiptStatusSuccessful {
-			return fmt.Errorf("tx failed: receipt status %v", receipt.Status)
+	if !c.SameAddress(signer, address) {
+		return &feetypes.TransactionError{
+			Code:    feetypes.TxErrorSignatureMismatch,
+			Message: fmt.Sprintf("tx signed by %s, expected the vault address %s", signer, address),
 		}
 	}
 	return nil
 }
 
-func (fp *FeePlugin) broadcast(
+// broadcastFeeTx sends a signed fee tx of the vault, it returns the endpoint which accepted it
+func (fp *FeePlugin) broadcastFeeTx(
 	ctx context.Context,
-	txBytes []byte,
-	signature tss.KeysignResponse,
-	signRequest vtypes.PluginKeysignRequest,
-) (*types.Transaction, error) {
-	tx, err := fp.eth.Send(
-		ctx,
-		txBytes,
-		gcommon.Hex2Bytes(signature.R),
-		gcommon.Hex2Bytes(signature.S),
-		gcommon.Hex2Bytes(signature.RecoveryID),
-	)
+	c Collector,
+	publickey string,
+	txHash string,
+	signed []byte,
+) (string, error) {
+	endpoint, err := c.Broadcast(ctx, signed)
 	if err != nil {
-		return nil, fmt.Errorf("p.eth.Send(tx_hex=%s): %w", gcommon.Bytes2Hex(txBytes), err)
+		fp.logger.WithError(err).Error("failed to complete signing process (broadcast tx)")
+		return "", fmt.Errorf("failed to complete signing process: %w", err)
 	}
 
 	fp.logger.WithFields(logrus.Fields{
-		"from_public_key": signRequest.PublicKey,
-		"to_address":      tx.To().Hex(),
-		"hash":            tx.Hash().Hex(),
-		"chain":           common.Ethereum.String(),
+		"from_public_key": publickey,
+		"hash":            txHash,
+		"chain":           c.Chain().String(),
+		"endpoint":        endpoint,
 	}).Info("tx successfully signed and broadcasted")
-	return tx, nil
+
+	// confirmations are tracked, and the fees reported as collected, by the fees:post_tx task
+	return endpoint, nil
 }
 
-func (fp *FeePlugin) genUnsignedTx(
-	ctx context.Context,
-	fromAddress string,
-	toAddress string,
-	contractAddress string,
-	amount *big.Int,
-) ([]byte, error) {
-	tx, err := fp.eth.MakeTxTransferERC20(
-		ctx,
-		gcommon.HexToAddress(fromAddress),
-		gcommon.HexToAddress(toAddress),
-		gcommon.HexToAddress(contractAddress),
-		amount,
-		0, // since we don't have a bunch of txs
-	)
+// assetLabel is the asset label of the send metrics, the native symbol for the native coin
+func assetLabel(asset string, chain common.Chain) string {
+	if asset == "" || asset == NativeAsset {
+		if symbol, err := chain.NativeSymbol(); err == nil {
+			return symbol
+		}
+		return chain.String()
+	}
+	return asset
+}
+
+// vaultAddress derives the address of the vault on the chain of the collector
+func (fp *FeePlugin) vaultAddress(c Collector, publickey string) (string, error) {
+	vault, err := getVaultForPubKey(fp.vault, publickey, fp.vaultEncryptionSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to get vault: %w", err)
+	}
+
+	address, err := c.Address(vault)
 	if err != nil {
-		return nil, fmt.Errorf("p.eth.MakeTxTransferNative: %v", err)
+		return "", fmt.Errorf("failed to get %s address: %w", c.Chain().String(), err)
+	}
+	return address, nil
+}
+
+// vaultAddresses derives the addresses of the vault on every configured chain
+func (fp *FeePlugin) vaultAddresses(publickey string) (map[common.Chain]string, error) {
+	vault, err := getVaultForPubKey(fp.vault, publickey, fp.vaultEncryptionSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vault: %w", err)
+	}
+
+	addresses := make(map[common.Chain]string, len(fp.collectors))
+	for _, c := range fp.collectors {
+		address, err := c.Address(vault)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s address: %w", c.Chain().String(), err)
+		}
+		addresses[c.Chain()] = address
 	}
-	return tx, nil
+	return addresses, nil
 }
 
 func getVaultForPubKey(s vault.Storage, pubKey, encryptionSecret string) (*v1.Vault, error) {
@@ -363,7 +762,7 @@ func getVaultForPubKey(s vault.Storage, pubKey, encryptionSecret string) (*v1.Va
 	}
 
 	if vaultContent == nil {
-		return nil, fmt.Errorf("vault not found")
+		return nil, feetypes.NewTransactionError(feetypes.TxErrorVaultMissing, errVaultNotFound)
 	}
 
 	return common.DecryptVaultFromBackup(encryptionSecret, vaultContent)
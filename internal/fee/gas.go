@@ -0,0 +1,89 @@
+package fee
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/sirupsen/logrus"
+	"github.com/vultisig/verifier/plugin/tasks"
+
+	"github.com/vultisig/feeplugin/internal/metrics"
+)
+
+// TransactPayload narrows a fees:transaction task down to a single vault, used for deferred collections
+type TransactPayload struct {
+	PublicKey string `json:"public_key"`
+}
+
+// gasDeferReason returns why the current network fees of the chain are above its configured ceilings, empty
+// if they are not or the chain has no fee market
+func (fp *FeePlugin) gasDeferReason(ctx context.Context, c Collector) (string, error) {
+	maxFee := c.Config().MaxFeePerGas
+	maxTip := c.Config().MaxPriorityFeePerGas
+	if maxFee == 0 && maxTip == 0 {
+		return "", nil
+	}
+
+	pricer, ok := c.(GasPricer)
+	if !ok {
+		return "", nil
+	}
+	tipCap, feeCap, err := pricer.GasPrices(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if maxTip != 0 && tipCap.Cmp(new(big.Int).SetUint64(maxTip)) > 0 {
+		return metrics.DeferReasonMaxPriorityFeePerGas, nil
+	}
+	if maxFee != 0 && feeCap.Cmp(new(big.Int).SetUint64(maxFee)) > 0 {
+		return metrics.DeferReasonMaxFeePerGas, nil
+	}
+	return "", nil
+}
+
+// deferCollection postpones the vault to the preferred window if one is configured and not open
+// yet, otherwise to the next fees:transaction run
+func (fp *FeePlugin) deferCollection(publickey, reason string) error {
+	if fp.metrics != nil {
+		fp.metrics.RecordCollectionDeferred(reason)
+	}
+
+	logger := fp.logger.WithFields(logrus.Fields{
+		"pubkey": publickey,
+		"reason": reason,
+	})
+
+	window := fp.config.Jobs.Transact.PreferredWindow
+	now := time.Now()
+	if !window.IsSet() || window.Contains(now) || fp.client == nil {
+		logger.Info("gas above ceiling, collection deferred to the next run")
+		return nil
+	}
+
+	payload, err := json.Marshal(TransactPayload{PublicKey: publickey})
+	if err != nil {
+		return fmt.Errorf("failed to marshal transact payload: %w", err)
+	}
+
+	// every run before the window defers the vault again, the task id keeps a single task per window
+	processAt := window.NextStart(now)
+	_, err = fp.client.Enqueue(
+		asynq.NewTask(TypeFeeTransact, payload),
+		asynq.Queue(tasks.QUEUE_NAME),
+		asynq.MaxRetry(3),
+		asynq.ProcessAt(processAt),
+		asynq.TaskID(fmt.Sprintf("%s:%s:%d", TypeFeeTransact, publickey, processAt.Unix())),
+	)
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("failed to enqueue deferred collection: %w", err)
+	}
+
+	logger.WithField("process_at", processAt).Info("gas above ceiling, collection deferred to the preferred window")
+	return nil
+}
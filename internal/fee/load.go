@@ -0,0 +1,63 @@
+package fee
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/sirupsen/logrus"
+)
+
+// HandleLoad is the fees:load task handler
+func (fp *FeePlugin) HandleLoad(ctx context.Context, _ *asynq.Task) error {
+	return fp.withJobLock(ctx, lockLoad, fp.LoadFees)
+}
+
+// LoadFees pulls the outstanding fees of every known public key from the verifier into the db
+func (fp *FeePlugin) LoadFees(ctx context.Context) error {
+	pks, err := fp.db.GetPublicKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get public keys: %w", err)
+	}
+	fp.logger.WithFields(logrus.Fields{
+		"pks": len(pks),
+	}).Info("requesting fees info")
+	startTime := time.Now()
+	var count, failed int
+	for _, pk := range pks {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// a failing vault is backed off on its own, it does not hold the others back
+		n, err := fp.loadPublicKeyFees(ctx, pk)
+		if err != nil {
+			fp.logger.WithError(err).WithField("pubkey", pk).Error("failed to load fees")
+			fp.recordVaultFailure(ctx, pk, err)
+			failed++
+			continue
+		}
+		count += n
+	}
+
+	fp.logger.WithFields(logrus.Fields{
+		"fees":     count,
+		"failed":   failed,
+		"duration": time.Since(startTime).String(),
+	}).Info("loaded fees")
+	return nil
+}
+
+func (fp *FeePlugin) loadPublicKeyFees(ctx context.Context, pk string) (int, error) {
+	fees, err := fp.verifierApi.GetPublicKeysFees(pk)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get fees: %w", verifierError(err))
+	}
+
+	err = fp.db.SyncFees(ctx, pk, fees)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store fees: %w", err)
+	}
+	return len(fees), nil
+}
@@ -0,0 +1,51 @@
+package fee
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// names of the advisory locks of the scheduled jobs, a single replica runs each at a time
+const (
+	lockLoad     = "feeplugin:" + TypeFeeLoad
+	lockTransact = "feeplugin:" + TypeFeeTransact
+	lockPostTx   = "feeplugin:" + TypeFeePostTx
+)
+
+// vaultLeaseMargin is added to Jobs.Transact.VaultTimeout for the vault lease, it outlives the processing
+// of the vault so another replica cannot take it over while the keysign is still running
+const vaultLeaseMargin = time.Minute
+
+// withJobLock runs the job only if no other replica is running it, else it skips this run
+func (fp *FeePlugin) withJobLock(ctx context.Context, name string, job func(context.Context) error) error {
+	release, ok, err := fp.db.TryAdvisoryLock(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to take %s lock: %w", name, err)
+	}
+	if !ok {
+		fp.logger.WithField("lock", name).Info("job running on another replica, skipping")
+		return nil
+	}
+	defer release()
+
+	return job(ctx)
+}
+
+// leaseVault takes the lease of the vault for this replica. It reports false, with a nil release, if
+// another replica is processing the vault.
+func (fp *FeePlugin) leaseVault(ctx context.Context, publickey string) (func(), bool, error) {
+	ttl := fp.config.Jobs.Transact.VaultTimeout + vaultLeaseMargin
+	ok, err := fp.db.AcquirePublicKeyLease(ctx, publickey, fp.instanceID, ttl)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	release := func() {
+		err := fp.db.ReleasePublicKeyLease(context.WithoutCancel(ctx), publickey, fp.instanceID)
+		if err != nil {
+			fp.logger.WithError(err).WithField("pubkey", publickey).Error("failed to release vault lease")
+		}
+	}
+	return release, true, nil
+}
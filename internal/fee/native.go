@@ -0,0 +1,51 @@
+package fee
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+)
+
+// nativeFeeAmount values the debt in wei of the native coin of the chain, with Config.NativeHaircut on
+// top. It reports whether the vault holds that much plus the network fee of the transfer.
+func (fp *FeePlugin) nativeFeeAmount(ctx context.Context, c Collector, address string, amount uint64) (*big.Int, bool, error) {
+	if c.Prices() == nil {
+		return nil, false, fmt.Errorf("no price source")
+	}
+
+	nativePrice, err := c.Prices().NativePrice(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get native price: %w", err)
+	}
+
+	withHaircut, _ := new(big.Float).Mul(
+		new(big.Float).SetUint64(amount),
+		big.NewFloat(1+fp.config.NativeHaircut),
+	).Int(nil)
+	wei := usdcToWei(withHaircut, nativePrice)
+
+	balance, err := c.Balance(ctx, address, NativeAsset)
+	if err != nil {
+		return nil, false, err
+	}
+	if balance.Cmp(wei) < 0 {
+		return wei, false, nil
+	}
+
+	tx, err := c.BuildTransfer(ctx, address, c.Config().TreasuryAddress, NativeAsset, wei)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to estimate native fee tx: %w", err)
+	}
+	info, err := c.TxInfo(tx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return wei, balance.Cmp(new(big.Int).Add(wei, info.Fee)) >= 0, nil
+}
+
+// usdcToWei converts an amount in USDC base units to wei at the given native coin price
+func usdcToWei(amount, nativePrice *big.Int) *big.Int {
+	v := new(big.Int).Mul(amount, big.NewInt(1e18))
+	return v.Div(v, nativePrice)
+}
@@ -0,0 +1,197 @@
+package fee
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/sirupsen/logrus"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+type planAction string
+
+const (
+	planActionNone              planAction = "none"               // the credits cover the debits
+	planActionSettle            planAction = "settle"             // confirmed instalments already cover the debt
+	planActionReview            planAction = "review"             // debt above the cap, the vault is flagged for review
+	planActionInsufficientFunds planAction = "insufficient_funds" // the vault can't pay, the fees stay pending
+	planActionDefer             planAction = "defer"              // gas above its ceiling
+	planActionBelowThreshold    planAction = "below_threshold"    // not worth collecting yet
+	planActionCollect           planAction = "collect"
+)
+
+// feePlan is what a run does with the pending fees of a vault
+type feePlan struct {
+	action    planAction
+	breakdown *FeeBreakdown
+	feeIds    []uint64
+	amount    uint64 // debt collected, settled or skipped, in USDC base units
+	partial   bool   // the collection is an instalment
+
+	chain   Collector // chain of the collection or of the skip
+	balance *big.Int  // USDC balance of the vault on chain, for insufficient funds
+	reason  string    // of a deferral or skip
+	message string    // of a skipped run
+
+	instalments []*feetypes.FeeRun // settling the debt
+
+	collection *collection
+	address    string // of the vault on the chain of the collection
+	tx         []byte // unsigned tx of the collection
+}
+
+// planCollection decides how the pending fees of the vault are collected, without any side effect: it
+// only reads the db and the chains, and nothing is signed. executeFeesTransaction applies the plan, the
+// dry run reports it.
+func (fp *FeePlugin) planCollection(ctx context.Context, publickey string, fees []feetypes.Fee) (*feePlan, error) {
+	if len(fp.collectors) == 0 {
+		return nil, fmt.Errorf("no chain configured")
+	}
+
+	addresses, err := fp.vaultAddresses(publickey)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &feePlan{}
+	for _, fee := range fees {
+		plan.feeIds = append(plan.feeIds, fee.FeeID)
+	}
+
+	plan.breakdown, err = AggregateFees(fees)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate fees: %w", err)
+	}
+	if plan.breakdown.Net.Sign() <= 0 {
+		plan.action = planActionNone
+		return plan, nil
+	}
+
+	// instalments already collected for these fees
+	instalments, err := fp.db.GetUnsettledFeeRuns(ctx, publickey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unsettled fee runs: %w", err)
+	}
+	paid := new(big.Int)
+	for _, instalment := range instalments {
+		paid.Add(paid, new(big.Int).SetUint64(instalment.TotalAmount))
+	}
+	if len(instalments) > 0 && paid.Cmp(plan.breakdown.Net) >= 0 {
+		if !paid.IsUint64() {
+			return nil, fmt.Errorf("instalments total %s does not fit a fee amount", paid.String())
+		}
+		plan.action = planActionSettle
+		plan.amount = paid.Uint64()
+		plan.instalments = instalments
+		return plan, nil
+	}
+
+	remaining := new(big.Int).Sub(plan.breakdown.Net, paid)
+	if !remaining.IsUint64() {
+		return nil, fmt.Errorf("debt %s does not fit a fee amount", remaining.String())
+	}
+	amount := remaining.Uint64()
+	partial := false
+	if amount > fp.config.MaxFeeAmount {
+		switch fp.config.MaxFeeAmountMode {
+		case MaxFeeAmountModeReview:
+			plan.action = planActionReview
+			plan.amount = amount
+			plan.chain = fp.collectors[0]
+			return plan, nil
+		default:
+			fp.logger.WithFields(logrus.Fields{
+				"pubkey": publickey,
+				"debt":   amount,
+				"cap":    fp.config.MaxFeeAmount,
+			}).Info("debt above max fee amount, collecting an instalment")
+			amount = fp.config.MaxFeeAmount
+			partial = true
+		}
+	}
+
+	// checked before signing, a transfer above the balance would only revert and waste a keysign session
+	c, best, balance, err := fp.usdcCollection(ctx, addresses, amount)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		c, err = fp.fallbackCollection(ctx, publickey, addresses, amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c == nil {
+		// on 18 decimal USDC a balance below one base unit of the debt converts to nothing to collect
+		switch {
+		case debtAmount(best, balance) == 0 || fp.config.InsufficientBalanceMode == InsufficientBalanceModeSkip:
+			plan.action = planActionInsufficientFunds
+			plan.amount = amount
+			plan.chain = best
+			plan.balance = balance
+			return plan, nil
+		default:
+			fp.logger.WithFields(logrus.Fields{
+				"pubkey":  publickey,
+				"chain":   best.Chain().String(),
+				"debt":    amount,
+				"balance": balance.String(),
+			}).Info("usdc balance below debt, collecting the available balance")
+			amount = debtAmount(best, balance)
+			c = &collection{
+				chain:  best,
+				method: feetypes.FeeRunMethodTransfer,
+				asset:  best.Config().UsdcAddress,
+				amount: usdcAmount(best, amount),
+			}
+			partial = true
+		}
+	}
+	plan.amount = amount
+	plan.partial = partial
+	plan.chain = c.chain
+	plan.balance = balance
+	plan.collection = c
+	plan.address = addresses[c.chain.Chain()]
+
+	reason, err := fp.gasDeferReason(ctx, c.chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check gas: %w", err)
+	}
+	if reason != "" {
+		plan.action = planActionDefer
+		plan.reason = reason
+		return plan, nil
+	}
+
+	reason, message := fp.minAmountSkipReason(amount)
+	if reason != "" {
+		plan.action = planActionBelowThreshold
+		plan.reason, plan.message = reason, message
+		return plan, nil
+	}
+
+	tx := c.tx
+	if tx == nil {
+		tx, err = c.chain.BuildTransfer(ctx, plan.address, c.chain.Config().TreasuryAddress, c.asset, c.amount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build fee tx: %w", err)
+		}
+	}
+	plan.tx = tx
+
+	reason, message, err = fp.gasRatioSkipReason(ctx, c.chain, plan.address, tx, amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check collection threshold: %w", err)
+	}
+	if reason != "" {
+		plan.action = planActionBelowThreshold
+		plan.reason, plan.message = reason, message
+		return plan, nil
+	}
+
+	plan.action = planActionCollect
+	return plan, nil
+}
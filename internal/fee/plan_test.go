@@ -0,0 +1,26 @@
+package fee
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+func TestPlanCollectionDust(t *testing.T) {
+	c := newFakeCollector()
+	c.config.UsdcDecimals = 18
+	// below one base unit of 6 decimal USDC
+	c.balances[testUsdcAddress] = big.NewInt(1e11)
+	fp := newTestFeePlugin(t, c, newFakeDB(), newFakeVerifier(t))
+	fp.config.MinCollectibleAmount = 0
+
+	plan, err := fp.planCollection(context.Background(), testPublicKey, []feetypes.Fee{debit(1, 5e6)})
+	if err != nil {
+		t.Fatalf("planCollection: %v", err)
+	}
+	if plan.action != planActionInsufficientFunds {
+		t.Errorf("action %s, want %s", plan.action, planActionInsufficientFunds)
+	}
+}
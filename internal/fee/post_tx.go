@@ -0,0 +1,164 @@
+package fee
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/sirupsen/logrus"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+// HandlePostTx is the fees:post_tx task handler
+func (fp *FeePlugin) HandlePostTx(ctx context.Context, _ *asynq.Task) error {
+	return fp.withJobLock(ctx, lockPostTx, fp.CheckFeeRuns)
+}
+
+// CheckFeeRuns looks up the on-chain outcome of every sent fee run. Stuck txs are replaced. Once its tx has
+// Jobs.Post.SuccessConfirmations confirmations the fees are reported to the verifier as collected
+// and the run is completed, together with the instalments collected for the same fees. Runs whose
+// tx reverted or was dropped are failed, which releases the fees for the next run.
+func (fp *FeePlugin) CheckFeeRuns(ctx context.Context) error {
+	runs, err := fp.db.GetFeeRunsByStatus(ctx, feetypes.FeeRunStateSent)
+	if err != nil {
+		return fmt.Errorf("failed to get sent fee runs: %w", err)
+	}
+
+	for _, run := range runs {
+		err := fp.checkFeeRun(ctx, run)
+		if err != nil {
+			fp.logger.WithError(err).WithField("fee_run_id", run.ID).Error("failed to check fee run")
+			fp.recordTxError(err)
+		}
+	}
+	return nil
+}
+
+func (fp *FeePlugin) checkFeeRun(ctx context.Context, run *feetypes.FeeRun) error {
+	if run.TxHash == nil {
+		return fmt.Errorf("fee run has no tx hash")
+	}
+
+	c, err := fp.collectorByName(run.Chain)
+	if err != nil {
+		return err
+	}
+
+	logger := fp.logger.WithFields(logrus.Fields{
+		"fee_run_id": run.ID,
+		"pubkey":     run.PublicKey,
+		"chain":      run.Chain,
+		"hash":       *run.TxHash,
+	})
+
+	attempts, err := fp.db.GetFeeRunAttempts(ctx, run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get fee run attempts: %w", err)
+	}
+	if len(attempts) == 0 {
+		// runs sent before attempts were tracked
+		attempts = []feetypes.FeeRunAttempt{{
+			Kind:      feetypes.FeeRunAttemptOriginal,
+			TxHash:    *run.TxHash,
+			CreatedAt: run.UpdatedAt,
+		}}
+	}
+
+	// the attempts share a nonce, at most one of them can be mined
+	var (
+		mined   *feetypes.FeeRunAttempt
+		receipt *Receipt
+	)
+	for i := range attempts {
+		r, err := c.Receipt(ctx, attempts[i].TxHash)
+		if err != nil {
+			return err
+		}
+		if r.Mined() {
+			mined, receipt = &attempts[i], r
+			break
+		}
+		// an attempt still in the mempool keeps the run pending, even when a later replacement is unknown
+		if receipt == nil || receipt.Status != ReceiptStatusPending {
+			receipt = r
+		}
+	}
+	if mined == nil {
+		// receipt is pending if any attempt is, else it is the one of the latest attempt
+		return fp.checkUnminedFeeRun(ctx, c, run, attempts, receipt, logger)
+	}
+
+	hash := mined.TxHash
+	logger = logger.WithField("hash", hash)
+
+	if mined.Kind == feetypes.FeeRunAttemptCancel {
+		logger.Warn("fee tx cancelled")
+		return fp.db.SetFeeRunFailed(ctx, run.ID, feetypes.TxErrorTxCancelled, fmt.Sprintf("tx cancelled: nonce %d taken by %s", mined.Nonce, hash))
+	}
+
+	if receipt.Status != ReceiptStatusSuccess {
+		logger.Warn("fee tx reverted")
+		fp.recordTxError(&feetypes.TransactionError{Code: feetypes.TxErrorReceiptReverted, Message: "receipt status failed"})
+		return fp.db.SetFeeRunFailed(ctx, run.ID, feetypes.TxErrorReceiptReverted, "tx reverted: receipt status failed")
+	}
+
+	confirmations := receipt.Confirmations
+	if !receipt.Finalized && confirmations < fp.config.Jobs.Post.SuccessConfirmations {
+		logger.WithField("confirmations", confirmations).Debug("waiting for more confirmations")
+		return nil
+	}
+
+	// an instalment only pays off part of the debt, its fees are reported once the last instalment is confirmed
+	if run.Partial {
+		logger.WithField("confirmations", confirmations).Info("fee instalment tx confirmed")
+		return fp.db.SetFeeRunCompleted(ctx, run.ID, hash)
+	}
+
+	total := run.TotalAmount
+	instalments, err := fp.db.GetUnsettledFeeRuns(ctx, run.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to get unsettled fee runs: %w", err)
+	}
+	for _, instalment := range instalments {
+		total += instalment.TotalAmount
+	}
+
+	// the run stays sent if the verifier can't be reached, and the commit is retried on the next check
+	err = fp.verifierApi.MarkFeeAsCollected(total, hash, run.Chain, run.FeeIDs...)
+	if err != nil {
+		return fmt.Errorf("failed to mark fee as collected: %w", verifierError(err))
+	}
+
+	logger.WithField("confirmations", confirmations).Info("fee tx confirmed")
+	return fp.db.SetFeeRunCompleted(ctx, run.ID, hash)
+}
+
+// checkUnminedFeeRun replaces the latest tx of the run once it is pending for longer than
+// Jobs.Post.StuckAfter, and fails the run if it is unknown to the node for longer than Jobs.Post.DropAfter
+func (fp *FeePlugin) checkUnminedFeeRun(
+	ctx context.Context,
+	c Collector,
+	run *feetypes.FeeRun,
+	attempts []feetypes.FeeRunAttempt,
+	receipt *Receipt,
+	logger *logrus.Entry,
+) error {
+	last := attempts[len(attempts)-1]
+
+	if receipt.Status == ReceiptStatusPending {
+		if time.Since(last.CreatedAt) < fp.config.Jobs.Post.StuckAfter {
+			return nil
+		}
+		return fp.replaceFeeTx(ctx, c, run, attempts, logger)
+	}
+
+	// updated_at of a sent run is the time its latest tx was broadcasted
+	if time.Since(run.UpdatedAt) < fp.config.Jobs.Post.DropAfter {
+		return nil
+	}
+
+	logger.Warn("fee tx dropped")
+	return fp.db.SetFeeRunFailed(ctx, run.ID, feetypes.TxErrorTxDropped, "tx dropped: not found on chain after "+fp.config.Jobs.Post.DropAfter.String())
+}
@@ -0,0 +1,359 @@
+package fee
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vultisig/vultisig-go/common"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+// sendTestRun collects a debt of 8 USDC from the test vault, it returns the sent run
+func sendTestRun(t *testing.T, fp *FeePlugin, c *fakeCollector, db *fakeDB) *feetypes.FeeRun {
+	t.Helper()
+	err := fp.executeFeesTransaction(context.Background(), testPublicKey, []feetypes.Fee{debit(1, 5e6), debit(2, 3e6)})
+	if err != nil {
+		t.Fatalf("executeFeesTransaction: %v", err)
+	}
+
+	if len(db.runs) != 1 {
+		t.Fatalf("%d fee runs, want 1", len(db.runs))
+	}
+	run := db.runs[0]
+	if run.Status != feetypes.FeeRunStateSent {
+		t.Fatalf("status = %s, want %s", run.Status, feetypes.FeeRunStateSent)
+	}
+	if run.TotalAmount != 8e6 || run.AssetAmount != "8000000" || run.Asset != testUsdcAddress {
+		t.Fatalf("run collects %d (%s of %s), want 8000000 of usdc", run.TotalAmount, run.AssetAmount, run.Asset)
+	}
+
+	attempts := db.attempts[run.ID]
+	if len(attempts) != 1 || attempts[0].Kind != feetypes.FeeRunAttemptOriginal {
+		t.Fatalf("attempts = %+v, want a single original attempt", attempts)
+	}
+	if attempts[0].TxHash != *run.TxHash || attempts[0].Endpoint != "node.test" {
+		t.Fatalf("attempt %s via %q, want %s via node.test", attempts[0].TxHash, attempts[0].Endpoint, *run.TxHash)
+	}
+	tx := decodeFakeTx(t, attempts[0].UnsignedTx)
+	if tx.From != testVaultAddress || tx.To != testTreasuryAddress {
+		t.Fatalf("tx from %s to %s, want from the vault to the treasury", tx.From, tx.To)
+	}
+	if len(c.sent) != 1 {
+		t.Fatalf("%d txs broadcasted, want 1", len(c.sent))
+	}
+	return run
+}
+
+func checkFeeRuns(t *testing.T, fp *FeePlugin) {
+	t.Helper()
+	err := fp.CheckFeeRuns(context.Background())
+	if err != nil {
+		t.Fatalf("CheckFeeRuns: %v", err)
+	}
+}
+
+func assertStatus(t *testing.T, run *feetypes.FeeRun, status feetypes.FeeRunState) {
+	t.Helper()
+	if run.Status != status {
+		t.Fatalf("status = %s, want %s", run.Status, status)
+	}
+}
+
+func assertCollected(t *testing.T, v *fakeVerifier, hash string) {
+	t.Helper()
+	if len(v.collected) != 1 {
+		t.Fatalf("%d collected reports, want 1", len(v.collected))
+	}
+	got := v.collected[0]
+	if got.TxHash != hash || got.Amount != 8e6 || got.Network != common.Ethereum.String() || !slices.Equal(got.IDs, []uint64{1, 2}) {
+		t.Fatalf("collected %+v, want fees 1 and 2 for 8000000 by %s", got, hash)
+	}
+}
+
+func TestCheckFeeRunsCompleted(t *testing.T) {
+	c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+	fp := newTestFeePlugin(t, c, db, v)
+	run := sendTestRun(t, fp, c, db)
+	hash := *run.TxHash
+
+	c.receipts[hash] = &Receipt{Status: ReceiptStatusSuccess, Confirmations: fp.config.Jobs.Post.SuccessConfirmations - 1}
+	checkFeeRuns(t, fp)
+	assertStatus(t, run, feetypes.FeeRunStateSent)
+	if len(v.collected) != 0 {
+		t.Fatalf("fees reported as collected before enough confirmations")
+	}
+
+	c.receipts[hash].Confirmations = fp.config.Jobs.Post.SuccessConfirmations
+	checkFeeRuns(t, fp)
+	assertStatus(t, run, feetypes.FeeRunStateSuccess)
+	if *run.TxHash != hash {
+		t.Errorf("tx hash = %s, want %s", *run.TxHash, hash)
+	}
+	assertCollected(t, v, hash)
+}
+
+func TestCheckFeeRunsFinalized(t *testing.T) {
+	c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+	fp := newTestFeePlugin(t, c, db, v)
+	fp.config.Jobs.Post.SuccessConfirmations = 64
+	run := sendTestRun(t, fp, c, db)
+	hash := *run.TxHash
+
+	// the node stops counting the confirmations of a finalized Solana tx at 32
+	c.receipts[hash] = &Receipt{Status: ReceiptStatusSuccess, Confirmations: solanaFinalizedConfirmations, Finalized: true}
+	checkFeeRuns(t, fp)
+
+	assertStatus(t, run, feetypes.FeeRunStateSuccess)
+	assertCollected(t, v, hash)
+}
+
+func TestCheckFeeRunsReverted(t *testing.T) {
+	c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+	fp := newTestFeePlugin(t, c, db, v)
+	run := sendTestRun(t, fp, c, db)
+
+	c.receipts[*run.TxHash] = &Receipt{Status: ReceiptStatusFailed, Confirmations: 1}
+	checkFeeRuns(t, fp)
+
+	assertStatus(t, run, feetypes.FeeRunStateFailed)
+	if run.ErrorCode == nil || *run.ErrorCode != feetypes.TxErrorReceiptReverted {
+		t.Errorf("error code = %v, want %s", run.ErrorCode, feetypes.TxErrorReceiptReverted)
+	}
+	if len(v.collected) != 0 {
+		t.Errorf("reverted fees reported as collected")
+	}
+}
+
+func TestCheckFeeRunsDropped(t *testing.T) {
+	c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+	fp := newTestFeePlugin(t, c, db, v)
+	run := sendTestRun(t, fp, c, db)
+	dropAfter := fp.config.Jobs.Post.DropAfter
+
+	// unknown to the node, but not for long enough
+	db.age(run.ID, dropAfter-time.Minute)
+	checkFeeRuns(t, fp)
+	assertStatus(t, run, feetypes.FeeRunStateSent)
+
+	db.age(run.ID, 2*time.Minute)
+	checkFeeRuns(t, fp)
+	assertStatus(t, run, feetypes.FeeRunStateFailed)
+	if run.ErrorCode == nil || *run.ErrorCode != feetypes.TxErrorTxDropped {
+		t.Errorf("error code = %v, want %s", run.ErrorCode, feetypes.TxErrorTxDropped)
+	}
+	if len(db.attempts[run.ID]) != 1 {
+		t.Errorf("a dropped tx was replaced")
+	}
+	if len(v.collected) != 0 {
+		t.Errorf("dropped fees reported as collected")
+	}
+}
+
+func TestCheckFeeRunsInstalments(t *testing.T) {
+	c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+	fp := newTestFeePlugin(t, c, db, v)
+	fees := []feetypes.Fee{debit(1, 5e6), debit(2, 3e6)}
+
+	// the vault holds part of the debt, it is collected as an instalment
+	c.balances[testUsdcAddress] = big.NewInt(5e6)
+	err := fp.executeFeesTransaction(context.Background(), testPublicKey, fees)
+	if err != nil {
+		t.Fatalf("executeFeesTransaction: %v", err)
+	}
+	instalment := db.runs[0]
+	if !instalment.Partial || instalment.TotalAmount != 5e6 {
+		t.Fatalf("run collects %d, partial %t, want an instalment of 5000000", instalment.TotalAmount, instalment.Partial)
+	}
+
+	c.receipts[*instalment.TxHash] = &Receipt{Status: ReceiptStatusSuccess, Confirmations: fp.config.Jobs.Post.SuccessConfirmations}
+	checkFeeRuns(t, fp)
+	assertStatus(t, instalment, feetypes.FeeRunStateSuccess)
+	if len(v.collected) != 0 || instalment.SettledAt != nil {
+		t.Fatalf("fees reported as collected by an instalment")
+	}
+
+	// the rest of the debt is collected once the vault is topped up
+	c.balances[testUsdcAddress] = big.NewInt(10e6)
+	err = fp.executeFeesTransaction(context.Background(), testPublicKey, fees)
+	if err != nil {
+		t.Fatalf("executeFeesTransaction: %v", err)
+	}
+	if len(db.runs) != 2 {
+		t.Fatalf("%d fee runs, want 2", len(db.runs))
+	}
+	rest := db.runs[1]
+	if rest.Partial || rest.TotalAmount != 3e6 {
+		t.Fatalf("run collects %d, partial %t, want the remaining 3000000", rest.TotalAmount, rest.Partial)
+	}
+
+	c.receipts[*rest.TxHash] = &Receipt{Status: ReceiptStatusSuccess, Confirmations: fp.config.Jobs.Post.SuccessConfirmations}
+	checkFeeRuns(t, fp)
+	assertStatus(t, rest, feetypes.FeeRunStateSuccess)
+	assertCollected(t, v, *rest.TxHash)
+	if instalment.SettledAt == nil || rest.SettledAt == nil {
+		t.Errorf("runs left unsettled once the fees are reported as collected")
+	}
+}
+
+func TestCheckFeeRunsSpeedUpMined(t *testing.T) {
+	c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+	fp := newTestFeePlugin(t, c, db, v)
+	run := sendTestRun(t, fp, c, db)
+	original := *run.TxHash
+
+	c.receipts[original] = &Receipt{Status: ReceiptStatusPending}
+	checkFeeRuns(t, fp)
+	if len(db.attempts[run.ID]) != 1 {
+		t.Fatalf("tx replaced before it was stuck")
+	}
+
+	db.age(run.ID, fp.config.Jobs.Post.StuckAfter+time.Minute)
+	checkFeeRuns(t, fp)
+
+	attempts := db.attempts[run.ID]
+	if len(attempts) != 2 || attempts[1].Kind != feetypes.FeeRunAttemptSpeedup {
+		t.Fatalf("attempts = %+v, want the original and a speedup", attempts)
+	}
+	speedup := attempts[1]
+	if speedup.Nonce != attempts[0].Nonce {
+		t.Errorf("speedup nonce = %d, want %d", speedup.Nonce, attempts[0].Nonce)
+	}
+	if fee := decodeFakeTx(t, speedup.UnsignedTx).Fee; fee.Cmp(big.NewInt(120)) != 0 {
+		t.Errorf("speedup fee = %s, want 120", fee)
+	}
+	if *run.TxHash != speedup.TxHash || len(c.sent) != 2 {
+		t.Fatalf("speedup %s is not the broadcasted tx of the run", speedup.TxHash)
+	}
+	assertStatus(t, run, feetypes.FeeRunStateSent)
+
+	c.receipts[original] = &Receipt{Status: ReceiptStatusNotFound}
+	c.receipts[speedup.TxHash] = &Receipt{Status: ReceiptStatusSuccess, Confirmations: fp.config.Jobs.Post.SuccessConfirmations}
+	checkFeeRuns(t, fp)
+
+	assertStatus(t, run, feetypes.FeeRunStateSuccess)
+	if *run.TxHash != speedup.TxHash {
+		t.Errorf("tx hash = %s, want the speedup %s", *run.TxHash, speedup.TxHash)
+	}
+	assertCollected(t, v, speedup.TxHash)
+}
+
+func TestCheckFeeRunsCancelMined(t *testing.T) {
+	c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+	fp := newTestFeePlugin(t, c, db, v)
+	fp.config.Jobs.Post.MaxSpeedups = 0
+	run := sendTestRun(t, fp, c, db)
+
+	c.receipts[*run.TxHash] = &Receipt{Status: ReceiptStatusPending}
+	db.age(run.ID, fp.config.Jobs.Post.StuckAfter+time.Minute)
+	checkFeeRuns(t, fp)
+
+	attempts := db.attempts[run.ID]
+	if len(attempts) != 2 || attempts[1].Kind != feetypes.FeeRunAttemptCancel {
+		t.Fatalf("attempts = %+v, want the original and a cancel", attempts)
+	}
+	cancel := attempts[1]
+	tx := decodeFakeTx(t, cancel.UnsignedTx)
+	if tx.Nonce != attempts[0].Nonce || tx.Amount.Sign() != 0 || tx.Asset != NativeAsset {
+		t.Errorf("cancel tx = %+v, want a zero value transfer with nonce %d", tx, attempts[0].Nonce)
+	}
+	if tx.To != testTreasuryAddress {
+		t.Errorf("cancel tx to %s, want %s", tx.To, testTreasuryAddress)
+	}
+
+	c.receipts[cancel.TxHash] = &Receipt{Status: ReceiptStatusSuccess, Confirmations: 1}
+	checkFeeRuns(t, fp)
+
+	assertStatus(t, run, feetypes.FeeRunStateFailed)
+	if run.ErrorCode == nil || *run.ErrorCode != feetypes.TxErrorTxCancelled {
+		t.Errorf("error code = %v, want %s", run.ErrorCode, feetypes.TxErrorTxCancelled)
+	}
+	if len(v.collected) != 0 {
+		t.Errorf("cancelled fees reported as collected")
+	}
+}
+
+func TestCheckFeeRunsSpeedUpBroadcastFailed(t *testing.T) {
+	c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+	fp := newTestFeePlugin(t, c, db, v)
+	run := sendTestRun(t, fp, c, db)
+	original := *run.TxHash
+
+	c.receipts[original] = &Receipt{Status: ReceiptStatusPending}
+	db.age(run.ID, fp.config.Jobs.Post.StuckAfter+time.Minute)
+	// the node may have accepted the speedup before the connection dropped
+	c.sendErr = errors.New("connection reset by peer")
+	checkFeeRuns(t, fp)
+
+	attempts := db.attempts[run.ID]
+	if len(attempts) != 2 || attempts[1].Kind != feetypes.FeeRunAttemptSpeedup {
+		t.Fatalf("attempts = %+v, want the original and the unconfirmed speedup", attempts)
+	}
+	speedup := attempts[1]
+	if *run.TxHash != speedup.TxHash || speedup.Endpoint != "" || speedup.SignedTx == "" {
+		t.Fatalf("speedup %+v is not the signed tx of the run without an endpoint", speedup)
+	}
+
+	// the speedup never reached the network, the pending original keeps the run from being dropped
+	c.sendErr = nil
+	db.age(run.ID, fp.config.Jobs.Post.DropAfter)
+	checkFeeRuns(t, fp)
+	assertStatus(t, run, feetypes.FeeRunStateSent)
+
+	// it did reach it and got mined
+	c.receipts[original] = &Receipt{Status: ReceiptStatusNotFound}
+	c.receipts[speedup.TxHash] = &Receipt{Status: ReceiptStatusSuccess, Confirmations: fp.config.Jobs.Post.SuccessConfirmations}
+	checkFeeRuns(t, fp)
+
+	assertStatus(t, run, feetypes.FeeRunStateSuccess)
+	assertCollected(t, v, speedup.TxHash)
+}
+
+func TestCheckFeeRunsSpeedUpRejected(t *testing.T) {
+	c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+	fp := newTestFeePlugin(t, c, db, v)
+	run := sendTestRun(t, fp, c, db)
+	original := *run.TxHash
+
+	c.receipts[original] = &Receipt{Status: ReceiptStatusPending}
+	db.age(run.ID, fp.config.Jobs.Post.StuckAfter+time.Minute)
+	c.sendErr = &feetypes.TransactionError{Code: feetypes.TxErrorBroadcastRejected, Message: "insufficient funds for gas * price + value"}
+	checkFeeRuns(t, fp)
+
+	if attempts := db.attempts[run.ID]; len(attempts) != 1 || attempts[0].TxHash != original {
+		t.Fatalf("attempts = %+v, want only the original", attempts)
+	}
+	if *run.TxHash != original {
+		t.Errorf("tx hash = %s, want the original %s", *run.TxHash, original)
+	}
+	assertStatus(t, run, feetypes.FeeRunStateSent)
+}
+
+func TestCheckFeeRunsSpeedUpLeased(t *testing.T) {
+	c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+	fp := newTestFeePlugin(t, c, db, v)
+	run := sendTestRun(t, fp, c, db)
+
+	c.receipts[*run.TxHash] = &Receipt{Status: ReceiptStatusPending}
+	db.age(run.ID, fp.config.Jobs.Post.StuckAfter+time.Minute)
+
+	// another replica is collecting the vault, the stuck tx is left to the next check
+	db.leases[testPublicKey] = "another replica"
+	checkFeeRuns(t, fp)
+	if attempts := db.attempts[run.ID]; len(attempts) != 1 {
+		t.Fatalf("attempts = %+v, want only the original", attempts)
+	}
+
+	delete(db.leases, testPublicKey)
+	checkFeeRuns(t, fp)
+	if attempts := db.attempts[run.ID]; len(attempts) != 2 || attempts[1].Kind != feetypes.FeeRunAttemptSpeedup {
+		t.Fatalf("attempts = %+v, want the original and a speedup", attempts)
+	}
+	if _, ok := db.leases[testPublicKey]; ok {
+		t.Errorf("vault lease not released after the speedup")
+	}
+}
@@ -0,0 +1,37 @@
+package fee
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+// preflight simulates the unsigned tx of the run on chains which support it. A tx the node rejects fails
+// with a simulation_revert TransactionError, a simulation which could not run at all is not held against it.
+func (fp *FeePlugin) preflight(ctx context.Context, c Collector, run *feetypes.FeeRun, address string, tx []byte) error {
+	sim, ok := c.(Simulator)
+	if !ok {
+		return nil
+	}
+
+	logger := fp.logger.WithFields(logrus.Fields{
+		"fee_run_id": run.ID,
+		"pubkey":     run.PublicKey,
+		"chain":      run.Chain,
+	})
+
+	gas, err := sim.Simulate(ctx, address, tx)
+	if err != nil {
+		if isTxError(err, feetypes.TxErrorSimulationRevert) {
+			logger.WithError(err).Warn("fee tx would revert, not signing it")
+			return err
+		}
+		logger.WithError(err).Warn("failed to simulate fee tx, signing it anyway")
+		return nil
+	}
+
+	logger.WithField("gas", gas).Debug("fee tx simulated")
+	return nil
+}
@@ -0,0 +1,113 @@
+package fee
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	goethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	gcommon "github.com/ethereum/go-ethereum/common"
+)
+
+const usdcDecimals = 6
+
+// PriceSource provides the price of the native coin of a chain, used to value gas and native collections
+type PriceSource interface {
+	// NativePrice returns the price of 1 native coin in USDC base units
+	NativePrice(ctx context.Context) (*big.Int, error)
+}
+
+const chainlinkAggregatorABI = `[
+	{"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"stateMutability":"view","type":"function"},
+	{"inputs":[],"name":"latestRoundData","outputs":[{"name":"roundId","type":"uint80"},{"name":"answer","type":"int256"},{"name":"startedAt","type":"uint256"},{"name":"updatedAt","type":"uint256"},{"name":"answeredInRound","type":"uint80"}],"stateMutability":"view","type":"function"}
+]`
+
+// ChainlinkPriceSource reads the native coin price from a Chainlink USD aggregator, USD is taken at par with USDC
+type ChainlinkPriceSource struct {
+	rpc    goethereum.ContractCaller
+	feed   gcommon.Address
+	maxAge time.Duration
+	abi    abi.ABI
+}
+
+func NewChainlinkPriceSource(rpc goethereum.ContractCaller, feedAddress string, maxAge time.Duration) (*ChainlinkPriceSource, error) {
+	parsed, err := abi.JSON(strings.NewReader(chainlinkAggregatorABI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse aggregator abi: %w", err)
+	}
+	return &ChainlinkPriceSource{
+		rpc:    rpc,
+		feed:   gcommon.HexToAddress(feedAddress),
+		maxAge: maxAge,
+		abi:    parsed,
+	}, nil
+}
+
+func (c *ChainlinkPriceSource) NativePrice(ctx context.Context) (*big.Int, error) {
+	out, err := c.call(ctx, "decimals")
+	if err != nil {
+		return nil, err
+	}
+	decimals, ok := out[0].(uint8)
+	if !ok {
+		return nil, fmt.Errorf("unexpected decimals type %T", out[0])
+	}
+
+	out, err = c.call(ctx, "latestRoundData")
+	if err != nil {
+		return nil, err
+	}
+	answer, ok := out[1].(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("unexpected answer type %T", out[1])
+	}
+	updatedAt, ok := out[3].(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("unexpected updatedAt type %T", out[3])
+	}
+
+	if answer.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid price %s", answer.String())
+	}
+	age := time.Since(time.Unix(updatedAt.Int64(), 0))
+	if c.maxAge > 0 && age > c.maxAge {
+		return nil, fmt.Errorf("stale price, updated %s ago", age.Round(time.Second))
+	}
+
+	return scaleDecimals(answer, int(decimals), usdcDecimals), nil
+}
+
+func (c *ChainlinkPriceSource) call(ctx context.Context, method string) ([]interface{}, error) {
+	data, err := c.abi.Pack(method)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack %s: %w", method, err)
+	}
+	res, err := c.rpc.CallContract(ctx, goethereum.CallMsg{To: &c.feed, Data: data}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s: %w", method, err)
+	}
+	out, err := c.abi.Unpack(method, res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack %s: %w", method, err)
+	}
+	return out, nil
+}
+
+func scaleDecimals(v *big.Int, from, to int) *big.Int {
+	if from == to {
+		return new(big.Int).Set(v)
+	}
+	if from > to {
+		return new(big.Int).Div(v, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(from-to)), nil))
+	}
+	return new(big.Int).Mul(v, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(to-from)), nil))
+}
+
+// weiToUsdc values an amount of wei in USDC base units at the given native coin price
+func weiToUsdc(wei, nativePrice *big.Int) *big.Int {
+	v := new(big.Int).Mul(wei, nativePrice)
+	return v.Div(v, big.NewInt(1e18))
+}
@@ -0,0 +1,129 @@
+package fee
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+// replaceFeeTx re-signs the stuck tx of the run with the same nonce. It is sped up with bumped fees
+// Jobs.Post.MaxSpeedups times, after that the nonce is taken by a zero value transfer which cancels it.
+// The cancel goes to the treasury rather than back to the vault, since the native fee transfer to the
+// treasury is the only native transfer the plugin policy allows. The vault is leased while the replacement
+// is signed, as in processVault, a vault another replica is collecting is left for the next check.
+func (fp *FeePlugin) replaceFeeTx(
+	ctx context.Context,
+	c Collector,
+	run *feetypes.FeeRun,
+	attempts []feetypes.FeeRunAttempt,
+	logger *logrus.Entry,
+) error {
+	replacer, ok := c.(Replacer)
+	if !ok {
+		logger.Debug("stuck fee tx can't be replaced on this chain")
+		return nil
+	}
+
+	release, leased, err := fp.leaseVault(ctx, run.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to lease vault: %w", err)
+	}
+	if !leased {
+		logger.Info("vault leased by another replica, not replacing its stuck fee tx")
+		return nil
+	}
+	defer release()
+
+	// another replica may have replaced the tx before the lease was taken
+	current, err := fp.db.GetFeeRunAttempts(ctx, run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get fee run attempts: %w", err)
+	}
+	if len(current) != len(attempts) {
+		logger.Info("stuck fee tx already replaced")
+		return nil
+	}
+
+	last := attempts[len(attempts)-1]
+	if last.UnsignedTx == "" {
+		return fmt.Errorf("fee run has no unsigned tx to replace")
+	}
+	lastTx, err := base64.StdEncoding.DecodeString(last.UnsignedTx)
+	if err != nil {
+		return fmt.Errorf("failed to decode b64 unsigned tx: %w", err)
+	}
+
+	var speedups uint64
+	for _, attempt := range attempts {
+		if attempt.Kind == feetypes.FeeRunAttemptSpeedup {
+			speedups++
+		}
+	}
+
+	kind := feetypes.FeeRunAttemptSpeedup
+	if last.Kind == feetypes.FeeRunAttemptCancel || speedups >= fp.config.Jobs.Post.MaxSpeedups {
+		kind = feetypes.FeeRunAttemptCancel
+	}
+
+	address, err := fp.vaultAddress(c, run.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	var tx []byte
+	switch kind {
+	case feetypes.FeeRunAttemptCancel:
+		tx, err = replacer.Cancel(ctx, address, c.Config().TreasuryAddress, lastTx, fp.config.Jobs.Post.GasBumpPercent)
+	default:
+		tx, err = replacer.SpeedUp(ctx, lastTx, fp.config.Jobs.Post.GasBumpPercent)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to build %s tx: %w", kind, err)
+	}
+
+	attempt, signed, err := fp.signFeeTx(ctx, c, run.PublicKey, address, kind, tx)
+	if err != nil {
+		fp.recordFeeTx(c, run, false)
+		return fmt.Errorf("failed to sign %s tx: %w", kind, err)
+	}
+
+	// stored before it is broadcasted like the original tx, a replacement which reached the network but
+	// not the database would leave the original not found and the run failed once its nonce is taken
+	err = fp.db.AddFeeRunAttempt(ctx, run.ID, *attempt)
+	if err != nil {
+		return fmt.Errorf("failed to add fee run attempt: %w", err)
+	}
+
+	endpoint, err := fp.broadcastFeeTx(ctx, c, run.PublicKey, attempt.TxHash, signed)
+	fp.recordFeeTx(c, run, err == nil)
+	if isTxError(err, feetypes.TxErrorBroadcastRejected) {
+		// the node answered, the replacement can't be mined and the run goes on with the previous tx
+		e := fp.db.DeleteFeeRunAttempt(ctx, run.ID, attempt.TxHash)
+		if e != nil {
+			logger.WithError(e).Error("failed to delete rejected fee run attempt")
+		}
+		return fmt.Errorf("failed to send %s tx: %w", kind, err)
+	}
+	if err != nil {
+		// the node may still have the replacement, it stays an attempt of the run
+		return fmt.Errorf("failed to send %s tx: %w", kind, err)
+	}
+
+	if endpoint != "" {
+		err = fp.db.SetFeeRunAttemptEndpoint(ctx, run.ID, attempt.TxHash, endpoint)
+		if err != nil {
+			return fmt.Errorf("failed to set fee run attempt endpoint: %w", err)
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"kind":     kind,
+		"nonce":    attempt.Nonce,
+		"new_hash": attempt.TxHash,
+	}).Warn("stuck fee tx replaced")
+	return nil
+}
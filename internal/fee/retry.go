@@ -0,0 +1,86 @@
+package fee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+var errVaultNotFound = errors.New("vault not found")
+
+// isPermanentFailure reports whether retrying the vault cannot succeed without an operator stepping in
+func isPermanentFailure(err error) bool {
+	switch feetypes.TransactionErrorCode(err) {
+	case feetypes.TxErrorVaultMissing, feetypes.TxErrorSignatureMismatch:
+		return true
+	}
+	return errors.Is(err, errUnknownTxType)
+}
+
+// isSharedFailure reports whether the failure comes from a dependency all the vaults share, it is not
+// held against the vault beyond its backoff
+func isSharedFailure(err error) bool {
+	switch feetypes.TransactionErrorCode(err) {
+	case feetypes.TxErrorVerifierUnavailable, feetypes.TxErrorVerifierUnauthorized:
+		return true
+	}
+	return false
+}
+
+// recordVaultFailure backs the vault off after a failure, and dead-letters it when the failure is
+// permanent or it failed Config.Retry.MaxAttempts times in a row. An unavailable verifier, or one refusing
+// the token of the plugin, never dead-letters a vault.
+func (fp *FeePlugin) recordVaultFailure(ctx context.Context, publickey string, cause error) {
+	// recorded even when the vault ran out of time
+	ctx = context.WithoutCancel(ctx)
+	logger := fp.logger.WithField("pubkey", publickey)
+	fp.recordTxError(cause)
+
+	attempts, err := fp.db.RecordPublicKeyFailure(
+		ctx,
+		publickey,
+		cause.Error(),
+		fp.config.Retry.Backoff,
+		fp.config.Retry.MaxBackoff,
+	)
+	if err != nil {
+		logger.WithError(err).Error("failed to record vault failure")
+		return
+	}
+
+	var reason string
+	switch {
+	case isPermanentFailure(cause):
+		reason = fmt.Sprintf("permanent failure: %s", cause.Error())
+	case isSharedFailure(cause):
+		logger.WithError(cause).WithField("attempts", attempts).Warn("vault failed on a shared dependency, backing off")
+		return
+	case attempts >= fp.config.Retry.MaxAttempts:
+		reason = fmt.Sprintf("failed %d times in a row, last: %s", attempts, cause.Error())
+	default:
+		logger.WithError(cause).WithField("attempts", attempts).Warn("vault failed, backing off")
+		return
+	}
+
+	err = fp.db.SetPublicKeyStatus(ctx, publickey, feetypes.PublicKeyStatusDeadLetter, reason)
+	if err != nil {
+		logger.WithError(err).Error("failed to dead-letter vault")
+		return
+	}
+	logger.WithFields(logrus.Fields{
+		"attempts": attempts,
+		"reason":   reason,
+	}).Error("vault dead-lettered")
+}
+
+// clearVaultFailures resets the backoff of the vault once it is handled successfully
+func (fp *FeePlugin) clearVaultFailures(ctx context.Context, publickey string) {
+	err := fp.db.ResetPublicKeyFailures(context.WithoutCancel(ctx), publickey)
+	if err != nil {
+		fp.logger.WithError(err).WithField("pubkey", publickey).Error("failed to reset vault failures")
+	}
+}
@@ -0,0 +1,408 @@
+package fee
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/gagliardetto/solana-go/programs/token"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+
+	v1 "github.com/vultisig/commondata/go/vultisig/vault/v1"
+	"github.com/vultisig/mobile-tss-lib/tss"
+	solanasdk "github.com/vultisig/recipes/sdk/solana"
+	vtypes "github.com/vultisig/verifier/types"
+	"github.com/vultisig/vultisig-go/address"
+	"github.com/vultisig/vultisig-go/common"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+const (
+	// lamportsPerSignature is the base fee of a Solana tx, fee txs carry no priority fee
+	lamportsPerSignature = 5000
+	// solanaFinalizedConfirmations stands for the confirmations of a finalized tx, which the node no
+	// longer counts once it is past the vote lockout
+	solanaFinalizedConfirmations = 32
+)
+
+// solanaRpc is the part of the Solana RPC used by the collector, rpc.Client implements it
+type solanaRpc interface {
+	solanasdk.RPCClient
+	GetBalance(ctx context.Context, account solana.PublicKey, commitment rpc.CommitmentType) (*rpc.GetBalanceResult, error)
+	GetTokenAccountBalance(ctx context.Context, account solana.PublicKey, commitment rpc.CommitmentType) (*rpc.GetTokenAccountBalanceResult, error)
+	GetLatestBlockhash(ctx context.Context, commitment rpc.CommitmentType) (*rpc.GetLatestBlockhashResult, error)
+	GetSignatureStatuses(ctx context.Context, searchTransactionHistory bool, signatures ...solana.Signature) (*rpc.GetSignatureStatusesResult, error)
+}
+
+// solanaCollector collects fees on Solana, as SPL token transfers to the associated token account of the
+// treasury signed with the EdDSA key of the vault. The policy engine only allows single instruction
+// txs, so the treasury token account has to exist beforehand.
+type solanaCollector struct {
+	config      ChainConfig
+	rpc         solanaRpc
+	sdk         *solanasdk.SDK
+	broadcaster *broadcaster
+}
+
+func newSolanaCollector(config ChainConfig, client solanaRpc) (*solanaCollector, error) {
+	sdk := solanasdk.NewSDK(client)
+	b, err := newBroadcaster(config, solanaSender(sdk), func(endpoint string) (sendFunc, error) {
+		return solanaSender(solanasdk.NewSDK(rpc.New(endpoint))), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up solana broadcast: %w", err)
+	}
+
+	return &solanaCollector{
+		config:      config,
+		rpc:         client,
+		sdk:         sdk,
+		broadcaster: b,
+	}, nil
+}
+
+func (c *solanaCollector) Chain() common.Chain {
+	return common.Solana
+}
+
+func (c *solanaCollector) Config() ChainConfig {
+	return c.config
+}
+
+// Prices returns nil, there is no price feed of SOL so fees are only collected in USDC on Solana
+func (c *solanaCollector) Prices() PriceSource {
+	return nil
+}
+
+func (c *solanaCollector) Address(vault *v1.Vault) (string, error) {
+	addr, _, _, err := address.GetAddress(vault.PublicKeyEddsa, vault.HexChainCode, common.Solana)
+	if err != nil {
+		return "", fmt.Errorf("failed to get solana address: %w", err)
+	}
+	return addr, nil
+}
+
+// SameAddress compares the public keys of the addresses, base58 is case sensitive
+func (c *solanaCollector) SameAddress(a, b string) bool {
+	keyA, err := solana.PublicKeyFromBase58(a)
+	if err != nil {
+		return false
+	}
+	keyB, err := solana.PublicKeyFromBase58(b)
+	if err != nil {
+		return false
+	}
+	return keyA.Equals(keyB)
+}
+
+// Balance returns the lamports of the address for NativeAsset, else what its associated token account
+// of the asset mint holds, zero if it has none
+func (c *solanaCollector) Balance(ctx context.Context, addr, asset string) (*big.Int, error) {
+	owner, err := solana.PublicKeyFromBase58(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid solana address %s: %w", addr, err)
+	}
+
+	if asset == NativeAsset {
+		res, err := c.rpc.GetBalance(ctx, owner, rpc.CommitmentConfirmed)
+		if err != nil {
+			return nil, fmt.Errorf("solRpc.GetBalance: %w", err)
+		}
+		return new(big.Int).SetUint64(res.Value), nil
+	}
+
+	amount, _, err := c.tokenBalance(ctx, owner, asset)
+	return amount, err
+}
+
+// tokenBalance returns what the associated token account of owner for mint holds, along with the
+// decimals of the mint
+func (c *solanaCollector) tokenBalance(ctx context.Context, owner solana.PublicKey, mint string) (*big.Int, uint8, error) {
+	ata, err := associatedTokenAccount(owner, mint)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res, err := c.rpc.GetTokenAccountBalance(ctx, ata, rpc.CommitmentConfirmed)
+	if err != nil {
+		// a vault which never held the token has no account for it
+		_, infoErr := c.rpc.GetAccountInfo(ctx, ata)
+		if errors.Is(infoErr, rpc.ErrNotFound) {
+			return big.NewInt(0), 0, nil
+		}
+		return nil, 0, fmt.Errorf("solRpc.GetTokenAccountBalance: %w", err)
+	}
+	if res.Value == nil {
+		return nil, 0, fmt.Errorf("no balance for token account %s", ata.String())
+	}
+
+	amount, ok := new(big.Int).SetString(res.Value.Amount, 10)
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid token amount %q", res.Value.Amount)
+	}
+	return amount, res.Value.Decimals, nil
+}
+
+// BuildTransfer builds a transferChecked of the asset mint between the associated token accounts of
+// from and to, or a system transfer of lamports for NativeAsset
+func (c *solanaCollector) BuildTransfer(ctx context.Context, from, to, asset string, amount *big.Int) ([]byte, error) {
+	if !amount.IsUint64() {
+		return nil, fmt.Errorf("amount %s does not fit in a solana transfer", amount.String())
+	}
+
+	fromKey, err := solana.PublicKeyFromBase58(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid solana address %s: %w", from, err)
+	}
+	toKey, err := solana.PublicKeyFromBase58(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid solana address %s: %w", to, err)
+	}
+
+	var inst solana.Instruction
+	if asset == NativeAsset {
+		inst, err = system.NewTransferInstruction(amount.Uint64(), fromKey, toKey).ValidateAndBuild()
+	} else {
+		inst, err = c.tokenTransfer(ctx, fromKey, toKey, asset, amount.Uint64())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blockhash, err := c.rpc.GetLatestBlockhash(ctx, rpc.CommitmentFinalized)
+	if err != nil {
+		return nil, fmt.Errorf("solRpc.GetLatestBlockhash: %w", err)
+	}
+
+	tx, err := solana.NewTransaction(
+		[]solana.Instruction{inst},
+		blockhash.Value.Blockhash,
+		solana.TransactionPayer(fromKey),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("solana.NewTransaction: %w", err)
+	}
+	return tx.MarshalBinary()
+}
+
+func (c *solanaCollector) tokenTransfer(
+	ctx context.Context,
+	from solana.PublicKey,
+	to solana.PublicKey,
+	asset string,
+	amount uint64,
+) (solana.Instruction, error) {
+	mint, err := solana.PublicKeyFromBase58(asset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mint %s: %w", asset, err)
+	}
+
+	// the decimals of transferChecked come from the source account, it has to exist to be debited anyway
+	balance, decimals, err := c.tokenBalance(ctx, from, asset)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Sign() == 0 {
+		return nil, fmt.Errorf("no %s balance to transfer", asset)
+	}
+	source, err := associatedTokenAccount(from, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	destination, err := associatedTokenAccount(to, asset)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.rpc.GetAccountInfo(ctx, destination)
+	if errors.Is(err, rpc.ErrNotFound) {
+		return nil, fmt.Errorf("treasury token account %s does not exist", destination.String())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("solRpc.GetAccountInfo: %w", err)
+	}
+
+	return token.NewTransferCheckedInstruction(
+		amount,
+		decimals,
+		source,
+		mint,
+		destination,
+		from,
+		nil,
+	).ValidateAndBuild()
+}
+
+// TxInfo decodes a tx built by BuildTransfer. Solana has no nonce, the recent blockhash bounds how long the
+// tx can be landed instead.
+func (c *solanaCollector) TxInfo(tx []byte) (*TxInfo, error) {
+	decoded, err := solana.TransactionFromBytes(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode solana tx: %w", err)
+	}
+
+	value := big.NewInt(0)
+	for _, inst := range decoded.Message.Instructions {
+		program, err := decoded.ResolveProgramIDIndex(inst.ProgramIDIndex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve program id: %w", err)
+		}
+		// lamports sent by a system transfer, its data is the u32 instruction index then the u64 amount
+		if program.Equals(solana.SystemProgramID) &&
+			len(inst.Data) == 12 &&
+			binary.LittleEndian.Uint32(inst.Data[:4]) == system.Instruction_Transfer {
+			value.Add(value, new(big.Int).SetUint64(binary.LittleEndian.Uint64(inst.Data[4:])))
+		}
+	}
+
+	signatures := uint64(decoded.Message.Header.NumRequiredSignatures)
+	return &TxInfo{
+		Value: value,
+		Fee:   new(big.Int).SetUint64(signatures * lamportsPerSignature),
+	}, nil
+}
+
+// SignRequest asks for an EdDSA signature of the message of the tx
+func (c *solanaCollector) SignRequest(policy vtypes.PluginPolicy, txID string, tx []byte) (*vtypes.PluginKeysignRequest, error) {
+	message, err := c.sdk.MessageHash(tx)
+	if err != nil {
+		return nil, fmt.Errorf("solanaSdk.MessageHash: %w", err)
+	}
+	msgHash := sha256.Sum256(message)
+
+	return &vtypes.PluginKeysignRequest{
+		KeysignRequest: vtypes.KeysignRequest{
+			PublicKey: policy.PublicKey,
+			Messages: []vtypes.KeysignMessage{
+				{
+					TxIndexerID:  txID,
+					Message:      base64.StdEncoding.EncodeToString(message),
+					Chain:        common.Solana,
+					Hash:         base64.StdEncoding.EncodeToString(msgHash[:]),
+					HashFunction: vtypes.HashFunction_SHA256,
+				},
+			},
+			PolicyID: policy.ID,
+			PluginID: policy.PluginID.String(),
+		},
+		Transaction: base64.StdEncoding.EncodeToString(tx),
+	}, nil
+}
+
+func (c *solanaCollector) SignedTx(tx []byte, sigs map[string]tss.KeysignResponse) ([]byte, error) {
+	signed, err := c.sdk.Sign(tx, sigs)
+	if err != nil {
+		return nil, fmt.Errorf("solanaSdk.Sign: %w", err)
+	}
+	return signed, nil
+}
+
+// Signer returns the fee payer of the tx once its signature is verified, an EdDSA key can't be recovered
+// from a signature
+func (c *solanaCollector) Signer(signed []byte) (string, error) {
+	decoded, err := solana.TransactionFromBytes(signed)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode signed solana tx: %w", err)
+	}
+
+	message, err := decoded.Message.MarshalBinary()
+	if err != nil {
+		return "", fmt.Errorf("failed to encode solana message: %w", err)
+	}
+	signers := decoded.Message.Signers()
+	if len(signers) == 0 || len(decoded.Signatures) == 0 {
+		return "", fmt.Errorf("signed solana tx has no signature")
+	}
+	if !decoded.Signatures[0].Verify(signers[0], message) {
+		return "", fmt.Errorf("%w by %s", errInvalidSignature, signers[0].String())
+	}
+	return signers[0].String(), nil
+}
+
+// TxHash returns the signature of the tx, which is its id on Solana
+func (c *solanaCollector) TxHash(signed []byte) (string, error) {
+	decoded, err := solana.TransactionFromBytes(signed)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode signed solana tx: %w", err)
+	}
+	if len(decoded.Signatures) == 0 {
+		return "", fmt.Errorf("signed solana tx has no signature")
+	}
+	return decoded.Signatures[0].String(), nil
+}
+
+// Broadcast sends the tx with the broadcast strategy of the chain
+func (c *solanaCollector) Broadcast(ctx context.Context, signed []byte) (string, error) {
+	return c.broadcaster.broadcast(ctx, signed)
+}
+
+// solanaSender sends signed txs through the sdk, an error the node answered with is a broadcast_rejected
+// TransactionError
+func solanaSender(sdk *solanasdk.SDK) sendFunc {
+	return func(ctx context.Context, signed []byte) (string, error) {
+		err := sdk.Broadcast(ctx, signed)
+		var rpcErr *jsonrpc.RPCError
+		if errors.As(err, &rpcErr) {
+			return "", &feetypes.TransactionError{
+				Code:    feetypes.TxErrorBroadcastRejected,
+				Message: rpcErr.Message,
+				Err:     err,
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("solanaSdk.Broadcast: %w", err)
+		}
+		return "", nil
+	}
+}
+
+// Receipt reports a tx unknown to the node as not found, Solana has no mempool to look it up in. Once its
+// blockhash expires such a tx can no longer land.
+func (c *solanaCollector) Receipt(ctx context.Context, hash string) (*Receipt, error) {
+	sig, err := solana.SignatureFromBase58(hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid solana signature %s: %w", hash, err)
+	}
+
+	res, err := c.rpc.GetSignatureStatuses(ctx, true, sig)
+	if errors.Is(err, rpc.ErrNotFound) {
+		return &Receipt{Status: ReceiptStatusNotFound}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("solRpc.GetSignatureStatuses: %w", err)
+	}
+	if len(res.Value) == 0 || res.Value[0] == nil {
+		return &Receipt{Status: ReceiptStatusNotFound}, nil
+	}
+	status := res.Value[0]
+
+	// the node stops counting the confirmations of a finalized tx
+	receipt := &Receipt{Status: ReceiptStatusSuccess, Confirmations: solanaFinalizedConfirmations, Finalized: true}
+	if status.Confirmations != nil {
+		receipt.Confirmations, receipt.Finalized = *status.Confirmations, false
+	}
+	if status.Err != nil {
+		receipt.Status = ReceiptStatusFailed
+	}
+	return receipt, nil
+}
+
+func associatedTokenAccount(owner solana.PublicKey, mint string) (solana.PublicKey, error) {
+	mintKey, err := solana.PublicKeyFromBase58(mint)
+	if err != nil {
+		return solana.PublicKey{}, fmt.Errorf("invalid mint %s: %w", mint, err)
+	}
+	ata, _, err := solana.FindAssociatedTokenAddress(owner, mintKey)
+	if err != nil {
+		return solana.PublicKey{}, fmt.Errorf("failed to derive token account: %w", err)
+	}
+	return ata, nil
+}
@@ -0,0 +1,412 @@
+package fee
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/gagliardetto/solana-go/programs/token"
+	"github.com/gagliardetto/solana-go/rpc"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+const testSolanaUsdcMint = "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"
+
+// solanaRpcStub answers the JSON-RPC requests of the Solana collector from in-memory accounts
+type solanaRpcStub struct {
+	server    *httptest.Server
+	blockhash solana.Hash
+	tokens    map[string]string          // token amount by token account, the account exists
+	decimals  uint8                      // of every token account
+	accounts  map[string]bool            // other existing accounts
+	statuses  map[string]json.RawMessage // signature status by signature, unknown when missing
+}
+
+func newSolanaRpcStub(t *testing.T) *solanaRpcStub {
+	t.Helper()
+	s := &solanaRpcStub{
+		blockhash: solana.Hash(solana.NewWallet().PublicKey()),
+		tokens:    map[string]string{},
+		decimals:  6,
+		accounts:  map[string]bool{},
+		statuses:  map[string]json.RawMessage{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *solanaRpcStub) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var first string
+	if len(req.Params) > 0 {
+		_ = json.Unmarshal(req.Params[0], &first)
+	}
+	rpcContext := map[string]any{"slot": 100}
+
+	var result any
+	switch req.Method {
+	case "getLatestBlockhash":
+		result = map[string]any{
+			"context": rpcContext,
+			"value":   map[string]any{"blockhash": s.blockhash.String(), "lastValidBlockHeight": 200},
+		}
+	case "getTokenAccountBalance":
+		amount, ok := s.tokens[first]
+		if !ok {
+			s.reply(w, req.ID, nil, map[string]any{"code": -32602, "message": "Invalid param: could not find account"})
+			return
+		}
+		result = map[string]any{
+			"context": rpcContext,
+			"value":   map[string]any{"amount": amount, "decimals": s.decimals, "uiAmountString": amount},
+		}
+	case "getAccountInfo":
+		var value any
+		if _, ok := s.tokens[first]; ok || s.accounts[first] {
+			value = map[string]any{
+				"data":       []string{"", "base64"},
+				"executable": false,
+				"lamports":   2039280,
+				"owner":      token.ProgramID.String(),
+				"rentEpoch":  0,
+			}
+		}
+		result = map[string]any{"context": rpcContext, "value": value}
+	case "getSignatureStatuses":
+		var sigs []string
+		_ = json.Unmarshal(req.Params[0], &sigs)
+		statuses := make([]json.RawMessage, len(sigs))
+		for i, sig := range sigs {
+			statuses[i] = json.RawMessage("null")
+			if status, ok := s.statuses[sig]; ok {
+				statuses[i] = status
+			}
+		}
+		result = map[string]any{"context": rpcContext, "value": statuses}
+	default:
+		s.reply(w, req.ID, nil, map[string]any{"code": -32601, "message": "Method not found"})
+		return
+	}
+	s.reply(w, req.ID, result, nil)
+}
+
+func (s *solanaRpcStub) reply(w http.ResponseWriter, id json.RawMessage, result any, rpcErr any) {
+	resp := map[string]any{"jsonrpc": "2.0", "id": id}
+	if rpcErr != nil {
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// addTokenAccount creates the USDC token account of owner holding amount
+func (s *solanaRpcStub) addTokenAccount(t *testing.T, owner solana.PublicKey, amount string) solana.PublicKey {
+	t.Helper()
+	ata, err := associatedTokenAccount(owner, testSolanaUsdcMint)
+	if err != nil {
+		t.Fatalf("associatedTokenAccount: %v", err)
+	}
+	s.tokens[ata.String()] = amount
+	return ata
+}
+
+func newTestSolanaCollector(t *testing.T, stub *solanaRpcStub) *solanaCollector {
+	t.Helper()
+	c, err := newSolanaCollector(ChainConfig{
+		Provider:    stub.server.URL,
+		UsdcAddress: testSolanaUsdcMint,
+	}, rpc.New(stub.server.URL))
+	if err != nil {
+		t.Fatalf("newSolanaCollector: %v", err)
+	}
+	return c
+}
+
+func TestSolanaBuildTransfer(t *testing.T) {
+	vault := solana.NewWallet().PublicKey()
+	treasury := solana.NewWallet().PublicKey()
+
+	tests := []struct {
+		name     string
+		source   string // token amount of the vault, no token account when empty
+		treasury bool   // the treasury token account exists
+		decimals uint8
+		err      string
+	}{
+		{
+			name:     "usdc transfer",
+			source:   "50000000",
+			treasury: true,
+			decimals: 6,
+		},
+		{
+			name:     "decimals of the source account",
+			source:   "50000000000",
+			treasury: true,
+			decimals: 9,
+		},
+		{
+			name:   "missing treasury token account",
+			source: "50000000",
+			err:    "treasury token account",
+		},
+		{
+			name:     "missing vault token account",
+			treasury: true,
+			err:      "no " + testSolanaUsdcMint + " balance",
+		},
+		{
+			name:     "empty vault token account",
+			source:   "0",
+			treasury: true,
+			err:      "no " + testSolanaUsdcMint + " balance",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newSolanaRpcStub(t)
+			stub.decimals = tt.decimals
+			var source, destination solana.PublicKey
+			if tt.source != "" {
+				source = stub.addTokenAccount(t, vault, tt.source)
+			}
+			if tt.treasury {
+				destination = stub.addTokenAccount(t, treasury, "0")
+			}
+			c := newTestSolanaCollector(t, stub)
+
+			tx, err := c.BuildTransfer(context.Background(), vault.String(), treasury.String(), testSolanaUsdcMint, big.NewInt(8e6))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildTransfer: %v", err)
+			}
+
+			decoded, err := solana.TransactionFromBytes(tx)
+			if err != nil {
+				t.Fatalf("failed to decode tx: %v", err)
+			}
+			if decoded.Message.RecentBlockhash != stub.blockhash {
+				t.Errorf("blockhash = %s, want %s", decoded.Message.RecentBlockhash, stub.blockhash)
+			}
+			if signers := decoded.Message.Signers(); len(signers) != 1 || !signers[0].Equals(vault) {
+				t.Errorf("signers = %v, want the vault as fee payer", signers)
+			}
+			if len(decoded.Message.Instructions) != 1 {
+				t.Fatalf("%d instructions, want a single transferChecked", len(decoded.Message.Instructions))
+			}
+
+			inst := decoded.Message.Instructions[0]
+			program, err := decoded.ResolveProgramIDIndex(inst.ProgramIDIndex)
+			if err != nil || !program.Equals(token.ProgramID) {
+				t.Fatalf("program = %s, want the token program", program)
+			}
+			// transferChecked data is the instruction index, the u64 amount then the u8 decimals
+			if len(inst.Data) != 10 || inst.Data[0] != token.Instruction_TransferChecked {
+				t.Fatalf("instruction data %x is not a transferChecked", inst.Data)
+			}
+			if amount := binary.LittleEndian.Uint64(inst.Data[1:9]); amount != 8e6 {
+				t.Errorf("amount = %d, want 8000000", amount)
+			}
+			if inst.Data[9] != tt.decimals {
+				t.Errorf("decimals = %d, want %d", inst.Data[9], tt.decimals)
+			}
+
+			accounts, err := inst.ResolveInstructionAccounts(&decoded.Message)
+			if err != nil {
+				t.Fatalf("failed to resolve accounts: %v", err)
+			}
+			mint := solana.MustPublicKeyFromBase58(testSolanaUsdcMint)
+			want := []solana.PublicKey{source, mint, destination, vault}
+			for i, account := range accounts {
+				if !account.PublicKey.Equals(want[i]) {
+					t.Errorf("account %d = %s, want %s", i, account.PublicKey, want[i])
+				}
+			}
+
+			info, err := c.TxInfo(tx)
+			if err != nil {
+				t.Fatalf("TxInfo: %v", err)
+			}
+			if info.Value.Sign() != 0 || info.Fee.Cmp(big.NewInt(lamportsPerSignature)) != 0 {
+				t.Errorf("tx info value %s fee %s, want no value and a single signature fee", info.Value, info.Fee)
+			}
+		})
+	}
+}
+
+func TestSolanaBuildNativeTransfer(t *testing.T) {
+	stub := newSolanaRpcStub(t)
+	c := newTestSolanaCollector(t, stub)
+	vault := solana.NewWallet().PublicKey()
+
+	tx, err := c.BuildTransfer(context.Background(), vault.String(), solana.NewWallet().PublicKey().String(), NativeAsset, big.NewInt(1e9))
+	if err != nil {
+		t.Fatalf("BuildTransfer: %v", err)
+	}
+	decoded, err := solana.TransactionFromBytes(tx)
+	if err != nil {
+		t.Fatalf("failed to decode tx: %v", err)
+	}
+	program, err := decoded.ResolveProgramIDIndex(decoded.Message.Instructions[0].ProgramIDIndex)
+	if err != nil || !program.Equals(system.ProgramID) {
+		t.Fatalf("program = %s, want the system program", program)
+	}
+
+	info, err := c.TxInfo(tx)
+	if err != nil {
+		t.Fatalf("TxInfo: %v", err)
+	}
+	if info.Value.Cmp(big.NewInt(1e9)) != 0 {
+		t.Errorf("value = %s, want 1000000000", info.Value)
+	}
+}
+
+func TestSolanaReceipt(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        string // raw signature status, unknown when empty
+		want          ReceiptStatus
+		confirmations uint64
+		finalized     bool
+	}{
+		{
+			name: "unknown",
+			want: ReceiptStatusNotFound,
+		},
+		{
+			name:          "confirmed",
+			status:        `{"slot": 90, "confirmations": 5, "err": null, "confirmationStatus": "confirmed"}`,
+			want:          ReceiptStatusSuccess,
+			confirmations: 5,
+		},
+		{
+			name:          "finalized without confirmations",
+			status:        `{"slot": 10, "confirmations": null, "err": null, "confirmationStatus": "finalized"}`,
+			want:          ReceiptStatusSuccess,
+			confirmations: solanaFinalizedConfirmations,
+			finalized:     true,
+		},
+		{
+			name:          "failed",
+			status:        `{"slot": 10, "confirmations": null, "err": {"InstructionError": [0, "Custom"]}, "confirmationStatus": "finalized"}`,
+			want:          ReceiptStatusFailed,
+			confirmations: solanaFinalizedConfirmations,
+			finalized:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newSolanaRpcStub(t)
+			c := newTestSolanaCollector(t, stub)
+
+			var sig solana.Signature
+			copy(sig[:], solana.NewWallet().PublicKey().Bytes())
+			if tt.status != "" {
+				stub.statuses[sig.String()] = json.RawMessage(tt.status)
+			}
+
+			r, err := c.Receipt(context.Background(), sig.String())
+			if err != nil {
+				t.Fatalf("Receipt: %v", err)
+			}
+			if r.Status != tt.want || r.Confirmations != tt.confirmations || r.Finalized != tt.finalized {
+				t.Errorf("receipt = %+v, want status %d with %d confirmations, finalized %t", r, tt.want, tt.confirmations, tt.finalized)
+			}
+		})
+	}
+}
+
+func TestSolanaSigner(t *testing.T) {
+	vault := solana.NewWallet()
+	other := solana.NewWallet()
+
+	stub := newSolanaRpcStub(t)
+	c := newTestSolanaCollector(t, stub)
+	tx, err := c.BuildTransfer(context.Background(), vault.PublicKey().String(), other.PublicKey().String(), NativeAsset, big.NewInt(1e9))
+	if err != nil {
+		t.Fatalf("BuildTransfer: %v", err)
+	}
+
+	sign := func(key solana.PrivateKey) []byte {
+		decoded, err := solana.TransactionFromBytes(tx)
+		if err != nil {
+			t.Fatalf("failed to decode tx: %v", err)
+		}
+		message, err := decoded.Message.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to encode message: %v", err)
+		}
+		sig, err := key.Sign(message)
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		decoded.Signatures = []solana.Signature{sig}
+		signed, err := decoded.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to encode signed tx: %v", err)
+		}
+		return signed
+	}
+
+	signed := sign(vault.PrivateKey)
+	signer, err := c.Signer(signed)
+	if err != nil {
+		t.Fatalf("Signer: %v", err)
+	}
+	if signer != vault.PublicKey().String() {
+		t.Errorf("signer = %s, want %s", signer, vault.PublicKey())
+	}
+	hash, err := c.TxHash(signed)
+	if err != nil {
+		t.Fatalf("TxHash: %v", err)
+	}
+	decoded, _ := solana.TransactionFromBytes(signed)
+	if hash != decoded.Signatures[0].String() {
+		t.Errorf("hash = %s, want the signature %s", hash, decoded.Signatures[0])
+	}
+
+	// signed by another key than the fee payer
+	_, err = c.Signer(sign(other.PrivateKey))
+	if !errors.Is(err, errInvalidSignature) {
+		t.Fatalf("err = %v, want errInvalidSignature", err)
+	}
+	if err := verifySigner(c, sign(other.PrivateKey), vault.PublicKey().String()); !isTxError(err, feetypes.TxErrorSignatureMismatch) {
+		t.Errorf("verifySigner err = %v, want a signature mismatch", err)
+	}
+	if err := verifySigner(c, signed, vault.PublicKey().String()); err != nil {
+		t.Errorf("verifySigner: %v", err)
+	}
+	// base58 addresses are case sensitive
+	if err := verifySigner(c, signed, swapCase(vault.PublicKey().String())); !isTxError(err, feetypes.TxErrorSignatureMismatch) {
+		t.Errorf("verifySigner err = %v, want a signature mismatch for the address in another case", err)
+	}
+}
@@ -5,16 +5,21 @@ import (
 
 	"github.com/vultisig/recipes/types"
 	"github.com/vultisig/verifier/plugin"
+	"github.com/vultisig/vultisig-go/common"
 )
 
 const PluginFees = "vultisig-fees-feee"
 
 type Spec struct {
 	plugin.Unimplemented
+	chains []common.Chain
 }
 
-func NewSpec() *Spec {
-	return &Spec{}
+// NewSpec advertises fee collection on the given chains, see FeeConfig.EnabledChains
+func NewSpec(chains []common.Chain) *Spec {
+	return &Spec{
+		chains: chains,
+	}
 }
 
 func (s *Spec) GetRecipeSpecification() (*types.RecipeSchema, error) {
@@ -26,7 +31,7 @@ func (s *Spec) GetRecipeSpecification() (*types.RecipeSchema, error) {
 		SupportedResources: s.buildSupportedResources(),
 		Requirements: &types.PluginRequirements{
 			MinVultisigVersion: 1,
-			SupportedChains:    getSupportedChainStrings(),
+			SupportedChains:    chainStrings(s.chains),
 		},
 		Permissions: []*types.Permission{
 			{
@@ -50,7 +55,7 @@ func (s *Spec) GetRecipeSpecification() (*types.RecipeSchema, error) {
 
 func (s *Spec) buildSupportedResources() []*types.ResourcePattern {
 	var resources []*types.ResourcePattern
-	for _, chain := range supportedChains {
+	for _, chain := range s.chains {
 		chainNameLower := strings.ToLower(chain.String())
 
 		resources = append(resources, &types.ResourcePattern{
@@ -90,6 +95,53 @@ func (s *Spec) buildSupportedResources() []*types.ResourcePattern {
 			},
 			Required: true,
 		})
+
+		// fees are only swapped on Ethereum, see fallbackCollection, the policy has no swap rule elsewhere
+		if chain != common.Ethereum {
+			continue
+		}
+		resources = append(resources, &types.ResourcePattern{
+			ResourcePath: &types.ResourcePath{
+				ChainId:    chainNameLower,
+				ProtocolId: "swap",
+				FunctionId: "Automatic token conversion",
+				Full:       chainNameLower + ".swap",
+			},
+			Target: types.TargetType_TARGET_TYPE_ADDRESS,
+			ParameterCapabilities: []*types.ParameterConstraintCapability{
+				{
+					ParameterName:  "from_asset",
+					SupportedTypes: types.ConstraintType_CONSTRAINT_TYPE_ANY,
+					Required:       true,
+				},
+				{
+					ParameterName:  "to_asset",
+					SupportedTypes: types.ConstraintType_CONSTRAINT_TYPE_FIXED,
+					Required:       true,
+				},
+				{
+					ParameterName:  "from_address",
+					SupportedTypes: types.ConstraintType_CONSTRAINT_TYPE_ANY,
+					Required:       true,
+				},
+				{
+					ParameterName:  "to_address",
+					SupportedTypes: types.ConstraintType_CONSTRAINT_TYPE_MAGIC_CONSTANT,
+					Required:       true,
+				},
+				{
+					ParameterName:  "from_amount",
+					SupportedTypes: types.ConstraintType_CONSTRAINT_TYPE_ANY,
+					Required:       true,
+				},
+				{
+					ParameterName:  "to_chain",
+					SupportedTypes: types.ConstraintType_CONSTRAINT_TYPE_FIXED,
+					Required:       true,
+				},
+			},
+			Required: false,
+		})
 	}
 
 	return resources
@@ -0,0 +1,22 @@
+package fee
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/vultisig/vultisig-go/common"
+)
+
+func TestSpecSupportedResources(t *testing.T) {
+	spec := NewSpec([]common.Chain{common.Ethereum, common.Arbitrum, common.Base, common.Solana})
+
+	var resources []string
+	for _, r := range spec.buildSupportedResources() {
+		resources = append(resources, r.ResourcePath.Full)
+	}
+
+	want := []string{"ethereum.send", "ethereum.swap", "arbitrum.send", "base.send", "solana.send"}
+	if !slices.Equal(resources, want) {
+		t.Errorf("resources = %v, want %v", resources, want)
+	}
+}
@@ -0,0 +1,179 @@
+package fee
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vultisig/recipes/sdk/swap"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+// SwapAggregator quotes and builds the swaps of fee collections, swap.OneInchProvider implements it
+type SwapAggregator interface {
+	GetQuote(ctx context.Context, req swap.QuoteRequest) (*swap.Quote, error)
+	BuildTx(ctx context.Context, req swap.SwapRequest) (*swap.SwapResult, error)
+}
+
+// swapCollection looks for an asset of Config.Swap.Assets which the vault holds enough of on the chain
+// to swap it to amount of USDC, and builds the swap with the treasury as recipient. It returns nil if none.
+func (fp *FeePlugin) swapCollection(
+	ctx context.Context,
+	c Collector,
+	publickey string,
+	address string,
+	amount uint64,
+) (*collection, error) {
+	caller, ok := c.(ContractCaller)
+	if !ok {
+		return nil, nil
+	}
+
+	router, err := swap.ResolveOneInchRouter(c.Chain().String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve swap router: %w", err)
+	}
+
+	for _, asset := range fp.config.Swap.Assets {
+		logger := fp.logger.WithFields(logrus.Fields{
+			"pubkey": publickey,
+			"chain":  c.Chain().String(),
+			"asset":  asset,
+		})
+
+		col, err := fp.swapCollectionFrom(ctx, c, caller, logger, address, asset, router.Address, amount)
+		if err != nil {
+			logger.WithError(err).Warn("failed to build fee swap")
+			continue
+		}
+		if col != nil {
+			return col, nil
+		}
+	}
+	return nil, nil
+}
+
+func (fp *FeePlugin) swapCollectionFrom(
+	ctx context.Context,
+	c Collector,
+	caller ContractCaller,
+	logger *logrus.Entry,
+	address string,
+	asset string,
+	router string,
+	amount uint64,
+) (*collection, error) {
+	native := asset == NativeAsset
+
+	balance, err := c.Balance(ctx, address, asset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balance: %w", err)
+	}
+	if balance.Sign() == 0 {
+		return nil, nil
+	}
+
+	from := swap.Asset{Chain: c.Chain().String()}
+	if !native {
+		from.Address = asset
+	}
+	to := swap.Asset{Chain: c.Chain().String(), Address: c.Config().UsdcAddress}
+
+	// how much of the balance is needed is estimated from a quote of the whole balance
+	quote, err := fp.swaps.GetQuote(ctx, swap.QuoteRequest{
+		From:        from,
+		To:          to,
+		Amount:      balance,
+		Sender:      address,
+		Destination: c.Config().TreasuryAddress,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quote: %w", err)
+	}
+
+	target, _ := new(big.Float).Mul(
+		new(big.Float).SetInt(usdcAmount(c, amount)),
+		big.NewFloat(1+fp.config.Swap.Haircut),
+	).Int(nil)
+	if quote.ExpectedOutput == nil || quote.ExpectedOutput.Cmp(target) < 0 {
+		return nil, nil
+	}
+
+	fromAmount := new(big.Int).Mul(balance, target)
+	fromAmount.Div(fromAmount, quote.ExpectedOutput)
+	fromAmount.Add(fromAmount, big.NewInt(1))
+	if fromAmount.Cmp(balance) > 0 {
+		fromAmount = balance
+	}
+
+	quote, err = fp.swaps.GetQuote(ctx, swap.QuoteRequest{
+		From:        from,
+		To:          to,
+		Amount:      fromAmount,
+		Sender:      address,
+		Destination: c.Config().TreasuryAddress,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quote: %w", err)
+	}
+	if quote.ExpectedOutput == nil || quote.ExpectedOutput.Cmp(usdcAmount(c, amount)) < 0 {
+		return nil, nil
+	}
+
+	// the policy has no rule for approvals, a token is only swapped within the allowance the vault already gave the router
+	if !native {
+		allowance, err := caller.Allowance(ctx, asset, address, router)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get allowance: %w", err)
+		}
+		if allowance.Cmp(fromAmount) < 0 {
+			logger.WithField("allowance", allowance.String()).Info("router allowance below swap amount, skipping asset")
+			return nil, nil
+		}
+	}
+
+	res, err := fp.swaps.BuildTx(ctx, swap.SwapRequest{
+		Quote:       quote,
+		Sender:      address,
+		Destination: c.Config().TreasuryAddress,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build swap: %w", err)
+	}
+
+	// the policy only allows swaps through the router, never sign calldata for another contract
+	if !strings.EqualFold(res.ToAddress, router) {
+		return nil, fmt.Errorf("swap tx to %s, expected the router %s", res.ToAddress, router)
+	}
+
+	tx, err := caller.BuildCall(ctx, address, router, res.Value, res.TxData)
+	if err != nil {
+		return nil, err
+	}
+
+	// the native coin swapped from has to leave the network fee of the swap in the vault
+	if native {
+		info, err := c.TxInfo(tx)
+		if err != nil {
+			return nil, err
+		}
+		if balance.Cmp(new(big.Int).Add(info.Value, info.Fee)) < 0 {
+			return nil, nil
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"amount":          fromAmount.String(),
+		"expected_output": quote.ExpectedOutput.String(),
+	}).Info("usdc balance below debt, collecting with a swap")
+	return &collection{
+		chain:  c,
+		method: feetypes.FeeRunMethodSwap,
+		asset:  asset,
+		amount: fromAmount,
+		tx:     tx,
+	}, nil
+}
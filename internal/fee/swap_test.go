@@ -0,0 +1,318 @@
+package fee
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vultisig/recipes/sdk/swap"
+
+	feetypes "github.com/vultisig/feeplugin/internal/types"
+)
+
+const (
+	testUsdtAddress   = "0xdAC17F958D2ee523a2206206994597C13D831ec7"
+	oneInchNative     = "0xEeeeeEeeeEeEeeEeEeEeeEEEeeeeEeeeeeeeEEeE"
+	testSwapCalldata  = "0x12aa3caf"
+	testOtherContract = "0x4444444444444444444444444444444444444444"
+)
+
+// testSwapAPI serves 1inch swap responses at a fixed rate, less a flat fee in USDC
+type testSwapAPI struct {
+	server   *httptest.Server
+	rate     *big.Rat // USDC base units per base unit of the source asset
+	fee      int64
+	to       string       // contract the swap tx calls
+	requests []url.Values // in order
+}
+
+func newTestSwapAPI(t *testing.T, rate *big.Rat, fee int64, to string) *testSwapAPI {
+	t.Helper()
+	api := &testSwapAPI{rate: rate, fee: fee, to: to}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/swap/v6.0/1/swap" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		api.requests = append(api.requests, q)
+
+		amount, ok := new(big.Int).SetString(q.Get("amount"), 10)
+		if !ok {
+			http.Error(w, "invalid amount", http.StatusBadRequest)
+			return
+		}
+		out := new(big.Rat).Mul(new(big.Rat).SetInt(amount), api.rate)
+		dst := new(big.Int).Quo(out.Num(), out.Denom())
+		dst.Sub(dst, big.NewInt(api.fee))
+
+		value := "0"
+		if q.Get("src") == oneInchNative {
+			value = amount.String()
+		}
+		resp := map[string]any{
+			"dstAmount": dst.String(),
+			"tx":        map[string]string{"to": api.to, "data": testSwapCalldata, "value": value},
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+// builds returns the requests which built a swap tx, the ones with a receiver
+func (api *testSwapAPI) builds() []url.Values {
+	var builds []url.Values
+	for _, q := range api.requests {
+		if q.Get("receiver") != "" {
+			builds = append(builds, q)
+		}
+	}
+	return builds
+}
+
+// testSwapAggregator is a SwapAggregator over the 1inch swap api of a testSwapAPI
+type testSwapAggregator struct {
+	url string
+}
+
+type testSwapResponse struct {
+	DstAmount string `json:"dstAmount"`
+	Tx        struct {
+		To    string `json:"to"`
+		Data  string `json:"data"`
+		Value string `json:"value"`
+	} `json:"tx"`
+}
+
+func (a *testSwapAggregator) swap(ctx context.Context, from, to swap.Asset, amount *big.Int, sender, receiver string) (*testSwapResponse, error) {
+	src, dst := from.Address, to.Address
+	if src == "" {
+		src = oneInchNative
+	}
+	q := url.Values{}
+	q.Set("src", src)
+	q.Set("dst", dst)
+	q.Set("amount", amount.String())
+	q.Set("from", sender)
+	if receiver != "" {
+		q.Set("receiver", receiver)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url+"/swap/v6.0/1/swap?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("swap api status %d", resp.StatusCode)
+	}
+
+	var res testSwapResponse
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (a *testSwapAggregator) GetQuote(ctx context.Context, req swap.QuoteRequest) (*swap.Quote, error) {
+	res, err := a.swap(ctx, req.From, req.To, req.Amount, req.Sender, "")
+	if err != nil {
+		return nil, err
+	}
+	out, ok := new(big.Int).SetString(res.DstAmount, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid dstAmount %s", res.DstAmount)
+	}
+	return &swap.Quote{FromAsset: req.From, ToAsset: req.To, FromAmount: req.Amount, ExpectedOutput: out}, nil
+}
+
+func (a *testSwapAggregator) BuildTx(ctx context.Context, req swap.SwapRequest) (*swap.SwapResult, error) {
+	res, err := a.swap(ctx, req.Quote.FromAsset, req.Quote.ToAsset, req.Quote.FromAmount, req.Sender, req.Destination)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(res.Tx.Data, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	value, _ := new(big.Int).SetString(res.Tx.Value, 10)
+	return &swap.SwapResult{TxData: data, Value: value, ToAddress: res.Tx.To, ExpectedOut: req.Quote.ExpectedOutput}, nil
+}
+
+func TestSwapCollectionFrom(t *testing.T) {
+	router, err := swap.ResolveOneInchRouter("Ethereum")
+	if err != nil {
+		t.Fatalf("ResolveOneInchRouter: %v", err)
+	}
+	usdtRate := big.NewRat(1, 1)
+	ethRate := big.NewRat(2000e6, 1e18) // 2000 USDC per ETH
+
+	tests := []struct {
+		name      string
+		asset     string
+		balance   int64
+		allowance int64
+		rate      *big.Rat
+		fee       int64  // flat fee of the swap api, in USDC base units
+		to        string // contract the swap api calls, the router when empty
+		amount    string // swapped, no collection when empty
+		err       string
+		built     bool
+	}{
+		{
+			name:      "token sized to the debt and haircut",
+			asset:     testUsdtAddress,
+			balance:   50e6,
+			allowance: 1e9,
+			rate:      usdtRate,
+			amount:    "10200001",
+			built:     true,
+		},
+		{
+			name:      "token balance below the haircut",
+			asset:     testUsdtAddress,
+			balance:   10_150_000,
+			allowance: 1e9,
+			rate:      usdtRate,
+		},
+		{
+			name:      "slippage of the sized quote above the haircut",
+			asset:     testUsdtAddress,
+			balance:   50e6,
+			allowance: 1e9,
+			rate:      usdtRate,
+			fee:       300_000,
+		},
+		{
+			name:      "allowance below the swap amount",
+			asset:     testUsdtAddress,
+			balance:   50e6,
+			allowance: 10_200_000,
+			rate:      usdtRate,
+		},
+		{
+			name:      "swap tx to another contract than the router",
+			asset:     testUsdtAddress,
+			balance:   50e6,
+			allowance: 1e9,
+			rate:      usdtRate,
+			to:        testOtherContract,
+			err:       "expected the router",
+			built:     true,
+		},
+		{
+			name:    "native coin without allowance",
+			asset:   NativeAsset,
+			balance: 1e16,
+			rate:    ethRate,
+			amount:  "5100000000000001",
+			built:   true,
+		},
+		{
+			name:    "native coin without the network fee left",
+			asset:   NativeAsset,
+			balance: 5_100_000_000_000_000,
+			rate:    ethRate,
+			built:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to := tt.to
+			if to == "" {
+				to = router.Address
+			}
+			api := newTestSwapAPI(t, tt.rate, tt.fee, to)
+
+			c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+			c.balances = map[string]*big.Int{tt.asset: big.NewInt(tt.balance)}
+			c.allowances = map[string]*big.Int{tt.asset: big.NewInt(tt.allowance)}
+			fp := newTestFeePlugin(t, c, db, v)
+			fp.swaps = &testSwapAggregator{url: api.server.URL}
+
+			col, err := fp.swapCollectionFrom(
+				context.Background(), c, c, fp.logger.WithField("test", tt.name),
+				testVaultAddress, tt.asset, router.Address, 10e6,
+			)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatalf("swapCollectionFrom: %v", err)
+			}
+
+			if builds := api.builds(); (len(builds) > 0) != tt.built {
+				t.Errorf("%d swap txs built, want built %t", len(builds), tt.built)
+			} else if tt.built && builds[0].Get("receiver") != testTreasuryAddress {
+				t.Errorf("swap receiver = %s, want the treasury", builds[0].Get("receiver"))
+			}
+
+			if tt.amount == "" {
+				if col != nil {
+					t.Fatalf("collection of %s, want none", col.amount)
+				}
+				return
+			}
+			if col == nil {
+				t.Fatalf("no collection, want a swap of %s", tt.amount)
+			}
+			if col.method != feetypes.FeeRunMethodSwap || col.asset != tt.asset || col.amount.String() != tt.amount {
+				t.Errorf("collection = %s %s of %s, want swap %s of %s", col.method, col.amount, col.asset, tt.amount, tt.asset)
+			}
+
+			// the whole balance is quoted first, then the amount it was sized to
+			if len(api.requests) != 3 || api.requests[1].Get("amount") != tt.amount || api.requests[2].Get("amount") != tt.amount {
+				t.Errorf("swap api requests = %v, want 2 quotes and a build of %s", api.requests, tt.amount)
+			}
+
+			var tx fakeTx
+			err = json.Unmarshal(col.tx, &tx)
+			if err != nil {
+				t.Fatalf("failed to decode swap tx: %v", err)
+			}
+			if tx.To != router.Address || hex.EncodeToString(tx.Data) != strings.TrimPrefix(testSwapCalldata, "0x") {
+				t.Errorf("swap tx calls %s with %x, want the router with %s", tx.To, tx.Data, testSwapCalldata)
+			}
+		})
+	}
+}
+
+func TestSwapCollectionSkipsAssets(t *testing.T) {
+	router, err := swap.ResolveOneInchRouter("Ethereum")
+	if err != nil {
+		t.Fatalf("ResolveOneInchRouter: %v", err)
+	}
+	api := newTestSwapAPI(t, big.NewRat(1, 1), 0, router.Address)
+
+	c, db, v := newFakeCollector(), newFakeDB(), newFakeVerifier(t)
+	const dai = "0x6B175474E89094C44Da98b954EedeAC495271d0F"
+	// no usdt allowance, dai can pay
+	c.balances = map[string]*big.Int{testUsdtAddress: big.NewInt(50e6), dai: big.NewInt(50e6)}
+	c.allowances = map[string]*big.Int{dai: big.NewInt(50e6)}
+	fp := newTestFeePlugin(t, c, db, v)
+	fp.swaps = &testSwapAggregator{url: api.server.URL}
+	fp.config.Swap.Assets = []string{NativeAsset, testUsdtAddress, dai}
+
+	col, err := fp.swapCollection(context.Background(), c, testPublicKey, testVaultAddress, 10e6)
+	if err != nil {
+		t.Fatalf("swapCollection: %v", err)
+	}
+	if col == nil || col.asset != dai {
+		t.Fatalf("collection = %+v, want a dai swap", col)
+	}
+}
@@ -0,0 +1,107 @@
+package fee
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vultisig/vultisig-go/common"
+
+	"github.com/vultisig/feeplugin/internal/metrics"
+)
+
+// minAmountSkipReason returns why amount is too small to be collected, empty if it is not. Along
+// with the reason comes a message for the skipped run.
+func (fp *FeePlugin) minAmountSkipReason(amount uint64) (string, string) {
+	if amount >= fp.config.MinCollectibleAmount {
+		return "", ""
+	}
+	return metrics.DeferReasonMinCollectibleAmount,
+		fmt.Sprintf("below threshold: amount %d is below min collectible amount %d", amount, fp.config.MinCollectibleAmount)
+}
+
+// gasRatioSkipReason returns why the gas of the fee tx is too large a share of amount, empty if it is
+// not. Along with the reason comes a message for the skipped run. Config.Validate requires a price feed on
+// every EVM chain, only Solana, whose fee txs cost a fixed few lamports, goes unchecked.
+func (fp *FeePlugin) gasRatioSkipReason(ctx context.Context, c Collector, from string, tx []byte, amount uint64) (string, string, error) {
+	prices := c.Prices()
+	if fp.config.MaxGasFeeRatio == 0 || prices == nil {
+		return "", "", nil
+	}
+
+	gasCost, err := fp.gasCost(ctx, c, from, tx)
+	if err != nil {
+		return "", "", err
+	}
+
+	nativePrice, err := prices.NativePrice(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get native price: %w", err)
+	}
+	gasCostUsdc := weiToUsdc(gasCost, nativePrice)
+
+	maxCost, _ := new(big.Float).Mul(
+		new(big.Float).SetUint64(amount),
+		big.NewFloat(fp.config.MaxGasFeeRatio),
+	).Int(nil)
+	if gasCostUsdc.Cmp(maxCost) > 0 {
+		return metrics.DeferReasonMaxGasFeeRatio,
+			fmt.Sprintf("below threshold: gas cost %s is above %.2f of amount %d", gasCostUsdc.String(), fp.config.MaxGasFeeRatio, amount),
+			nil
+	}
+	return "", "", nil
+}
+
+// gasCost returns the network fee the tx is expected to pay, the gas its simulation uses at the current
+// base fee plus tip. A tx which cannot be simulated is priced at its worst case fee, a revert is left to
+// the preflight.
+func (fp *FeePlugin) gasCost(ctx context.Context, c Collector, from string, tx []byte) (*big.Int, error) {
+	sim, isSim := c.(Simulator)
+	pricer, isPricer := c.(GasPricer)
+	if isSim && isPricer {
+		gas, err := sim.Simulate(ctx, from, tx)
+		if err == nil {
+			price, err := pricer.GasPrice(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get gas price: %w", err)
+			}
+			return new(big.Int).Mul(new(big.Int).SetUint64(gas), price), nil
+		}
+		fp.logger.WithError(err).Debug("failed to simulate fee tx, pricing its worst case gas")
+	}
+
+	info, err := c.TxInfo(tx)
+	if err != nil {
+		return nil, err
+	}
+	return info.Fee, nil
+}
+
+// skipBelowThreshold records the vault as not worth collecting yet, the fees stay pending and keep accruing
+func (fp *FeePlugin) skipBelowThreshold(
+	ctx context.Context,
+	publickey string,
+	chain common.Chain,
+	amount uint64,
+	feeIds []uint64,
+	reason string,
+	message string,
+) error {
+	if fp.metrics != nil {
+		fp.metrics.RecordCollectionDeferred(reason)
+		fp.metrics.RecordBelowThresholdDebt(amount)
+	}
+
+	_, err := fp.db.CreateSkippedFeeRun(ctx, publickey, chain, amount, feeIds, "", message)
+	if err != nil {
+		return fmt.Errorf("failed to create skipped fee run: %w", err)
+	}
+
+	fp.logger.WithFields(logrus.Fields{
+		"pubkey": publickey,
+		"debt":   amount,
+		"reason": reason,
+	}).Info("collection not economical yet, skipping")
+	return nil
+}
@@ -0,0 +1,77 @@
+package fee
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/vultisig/feeplugin/internal/metrics"
+)
+
+type fixedPrice struct{ price *big.Int }
+
+func (p fixedPrice) NativePrice(context.Context) (*big.Int, error) { return p.price, nil }
+
+// gasPricedCollector is a fakeCollector of a chain with a price feed, a fee market and simulations
+type gasPricedCollector struct {
+	*fakeCollector
+	gas      uint64
+	gasPrice *big.Int
+	simErr   error
+}
+
+func (c *gasPricedCollector) Prices() PriceSource {
+	return fixedPrice{price: big.NewInt(2000e6)}
+}
+
+func (c *gasPricedCollector) GasPrices(context.Context) (*big.Int, *big.Int, error) {
+	return c.gasPrice, c.gasPrice, nil
+}
+
+func (c *gasPricedCollector) GasPrice(context.Context) (*big.Int, error) {
+	return c.gasPrice, nil
+}
+
+func (c *gasPricedCollector) Simulate(context.Context, string, []byte) (uint64, error) {
+	return c.gas, c.simErr
+}
+
+func TestGasRatioSkipReason(t *testing.T) {
+	// 0.02 ETH worst case, 40 USDC at 2000 USDC per ETH
+	tx, err := json.Marshal(fakeTx{Nonce: 7, Asset: testUsdcAddress, Amount: big.NewInt(100e6), Fee: big.NewInt(2e16)})
+	if err != nil {
+		t.Fatalf("failed to encode tx: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		simErr error
+		reason string
+	}{
+		// 50000 gas at 11 gwei, 1.1 USDC
+		{name: "simulated gas", reason: ""},
+		{name: "simulation failed", simErr: errors.New("node down"), reason: metrics.DeferReasonMaxGasFeeRatio},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &gasPricedCollector{
+				fakeCollector: newFakeCollector(),
+				gas:           50000,
+				gasPrice:      big.NewInt(11e9),
+				simErr:        tt.simErr,
+			}
+			fp := newTestFeePlugin(t, c, newFakeDB(), newFakeVerifier(t))
+			fp.config.MaxGasFeeRatio = 0.1
+
+			reason, _, err := fp.gasRatioSkipReason(context.Background(), c, testVaultAddress, tx, 100e6)
+			if err != nil {
+				t.Fatalf("gasRatioSkipReason: %v", err)
+			}
+			if reason != tt.reason {
+				t.Errorf("reason %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+}
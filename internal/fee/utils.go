@@ -11,9 +11,10 @@ import (
 	"github.com/vultisig/recipes/chain/evm/ethereum"
 )
 
-func ComputeTxHash(proposedTx []byte, sigs map[string]tss.KeysignResponse, chainId *big.Int) (string, error) {
+// SignedEvmTx attaches the signature of sigs to the unsigned EVM tx
+func SignedEvmTx(proposedTx []byte, sigs map[string]tss.KeysignResponse, chainId *big.Int) (*types.Transaction, error) {
 	if len(sigs) != 1 {
-		return "", fmt.Errorf("expected exactly one signature, got %d", len(sigs))
+		return nil, fmt.Errorf("expected exactly one signature, got %d", len(sigs))
 	}
 
 	var sigRes tss.KeysignResponse
@@ -23,7 +24,7 @@ func ComputeTxHash(proposedTx []byte, sigs map[string]tss.KeysignResponse, chain
 
 	payloadDecoded, err := ethereum.DecodeUnsignedPayload(proposedTx)
 	if err != nil {
-		return "", fmt.Errorf("DecodeUnsignedPayload: %w", err)
+		return nil, fmt.Errorf("DecodeUnsignedPayload: %w", err)
 	}
 
 	var sig []byte
@@ -33,7 +34,7 @@ func ComputeTxHash(proposedTx []byte, sigs map[string]tss.KeysignResponse, chain
 
 	tx, err := types.NewTx(payloadDecoded).WithSignature(types.LatestSignerForChainID(chainId), sig)
 	if err != nil {
-		return "", fmt.Errorf("NewTx.WithSignature: %w", err)
+		return nil, fmt.Errorf("NewTx.WithSignature: %w", err)
 	}
-	return tx.Hash().Hex(), nil
+	return tx, nil
 }
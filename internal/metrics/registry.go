@@ -64,7 +64,15 @@ func registerWorkerMetrics(logger *logrus.Logger) {
 	registerIfNotExists(workerLastExecutionTimestamp, "worker_last_execution_timestamp", logger)
 	registerIfNotExists(workerFeeExecutionDuration, "worker_fee_execution_duration", logger)
 	registerIfNotExists(workerErrorsTotal, "worker_errors_total", logger)
+	registerIfNotExists(workerTransactionErrorsTotal, "worker_transaction_errors_total", logger)
 	registerIfNotExists(workerTransactionProcessingDuration, "worker_transaction_processing_duration", logger)
+	registerIfNotExists(workerCollectionDeferredTotal, "worker_collection_deferred_total", logger)
+	registerIfNotExists(workerBelowThresholdDebt, "worker_below_threshold_debt", logger)
+
+	// the worker is the only service with RPC clients
+	registerIfNotExists(rpcRequestsTotal, "rpc_requests_total", logger)
+	registerIfNotExists(rpcEndpointHealthy, "rpc_endpoint_healthy", logger)
+	registerIfNotExists(rpcEndpointBlockLag, "rpc_endpoint_block_lag", logger)
 }
 
 // registerTxIndexerMetrics registers tx_indexer-related metrics
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Status of an RPC request
+const (
+	RpcStatusSuccess     = "success"
+	RpcStatusRejected    = "rejected"    // the node answered with an error
+	RpcStatusUnavailable = "unavailable" // the node could not be reached, the request moved to the next endpoint
+)
+
+var (
+	// Requests by RPC endpoint
+	rpcRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "fee",
+			Subsystem: "rpc",
+			Name:      "requests_total",
+			Help:      "Total number of RPC requests by endpoint",
+		},
+		[]string{"chain", "endpoint", "status"}, // success, rejected, unavailable
+	)
+
+	// Health of RPC endpoints
+	rpcEndpointHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "fee",
+			Subsystem: "rpc",
+			Name:      "endpoint_healthy",
+			Help:      "Whether the RPC endpoint is in rotation, 1 if healthy",
+		},
+		[]string{"chain", "endpoint"},
+	)
+
+	// Block lag of RPC endpoints
+	rpcEndpointBlockLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "fee",
+			Subsystem: "rpc",
+			Name:      "endpoint_block_lag",
+			Help:      "Blocks the RPC endpoint is behind the most advanced endpoint of the chain at the last health check",
+		},
+		[]string{"chain", "endpoint"},
+	)
+)
+
+// RpcMetrics provides methods to update RPC endpoint metrics
+type RpcMetrics struct{}
+
+// NewRpcMetrics creates a new instance of RpcMetrics
+func NewRpcMetrics() *RpcMetrics {
+	return &RpcMetrics{}
+}
+
+// RecordRequest records a request sent to an endpoint
+func (rm *RpcMetrics) RecordRequest(chain, endpoint, status string) {
+	rpcRequestsTotal.WithLabelValues(chain, endpoint, status).Inc()
+}
+
+// RecordEndpointHealth records whether an endpoint is in rotation
+func (rm *RpcMetrics) RecordEndpointHealth(chain, endpoint string, healthy bool) {
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	rpcEndpointHealthy.WithLabelValues(chain, endpoint).Set(value)
+}
+
+// RecordBlockLag records how far behind the other endpoints of the chain an endpoint is
+func (rm *RpcMetrics) RecordBlockLag(chain, endpoint string, lag uint64) {
+	rpcEndpointBlockLag.WithLabelValues(chain, endpoint).Set(float64(lag))
+}
@@ -68,6 +68,38 @@ var (
 		[]string{"error_type"}, // validation, execution, signing, network
 	)
 
+	// Typed failures of the fee pipeline
+	workerTransactionErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "fee",
+			Subsystem: "worker",
+			Name:      "transaction_errors_total",
+			Help:      "Total number of fee pipeline failures by TransactionError code",
+		},
+		[]string{"code"}, // verifier_unavailable, vault_missing, insufficient_balance, simulation_revert, keysign_timeout, signature_mismatch, broadcast_rejected, receipt_reverted
+	)
+
+	// Vaults whose collection was postponed
+	workerCollectionDeferredTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "fee",
+			Subsystem: "worker",
+			Name:      "collection_deferred_total",
+			Help:      "Total number of vault collections deferred",
+		},
+		[]string{"reason"}, // max_fee_per_gas, max_priority_fee_per_gas, min_collectible_amount, max_gas_fee_ratio
+	)
+
+	// Debt left to accrue because collecting it is not economical yet
+	workerBelowThresholdDebt = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "fee",
+			Subsystem: "worker",
+			Name:      "below_threshold_debt",
+			Help:      "Pending debt in USDC base units of the vaults below the collection threshold in the last run",
+		},
+	)
+
 	// Transaction processing metrics
 	workerTransactionProcessingDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -114,6 +146,26 @@ func (wm *WorkerMetrics) RecordError(errorType string) {
 	workerErrorsTotal.WithLabelValues(errorType).Inc()
 }
 
+// RecordTransactionError records a failure of the fee pipeline by its TransactionError code
+func (wm *WorkerMetrics) RecordTransactionError(code string) {
+	workerTransactionErrorsTotal.WithLabelValues(code).Inc()
+}
+
+// RecordCollectionDeferred records a vault collection postponed to a later run
+func (wm *WorkerMetrics) RecordCollectionDeferred(reason string) {
+	workerCollectionDeferredTotal.WithLabelValues(reason).Inc()
+}
+
+// ResetBelowThresholdDebt clears the below threshold debt at the start of a run
+func (wm *WorkerMetrics) ResetBelowThresholdDebt() {
+	workerBelowThresholdDebt.Set(0)
+}
+
+// RecordBelowThresholdDebt adds the debt of a vault left to accrue
+func (wm *WorkerMetrics) RecordBelowThresholdDebt(amount uint64) {
+	workerBelowThresholdDebt.Add(float64(amount))
+}
+
 // RecordFeeExecution records fee processing time
 func (wm *WorkerMetrics) RecordFeeExecution(duration time.Duration) {
 	workerFeeExecutionDuration.Observe(duration.Seconds())
@@ -169,3 +221,11 @@ const (
 	ErrorTypeSigning    = "signing"
 	ErrorTypeNetwork    = "network"
 )
+
+// Deferral reason constants for consistent labeling
+const (
+	DeferReasonMaxFeePerGas         = "max_fee_per_gas"
+	DeferReasonMaxPriorityFeePerGas = "max_priority_fee_per_gas"
+	DeferReasonMinCollectibleAmount = "min_collectible_amount"
+	DeferReasonMaxGasFeeRatio       = "max_gas_fee_ratio"
+)
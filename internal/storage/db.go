@@ -2,8 +2,14 @@ package storage
 
 import (
 	"context"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
+	vtypes "github.com/vultisig/verifier/types"
+	"github.com/vultisig/vultisig-go/common"
+
+	"github.com/vultisig/feeplugin/internal/types"
 )
 
 type DatabaseStorage interface {
@@ -11,6 +17,38 @@ type DatabaseStorage interface {
 
 	GetPublicKeys(ctx context.Context) ([]string, error)
 	InsertPublicKey(ctx context.Context, publicKey string) error
+	GetPublicKeysByStatus(ctx context.Context, status types.PublicKeyStatus) ([]string, error)
+	GetHeldPublicKeys(ctx context.Context) ([]*types.PublicKey, error)
+	SetPublicKeyStatus(ctx context.Context, publicKey string, status types.PublicKeyStatus, reason string) error
+	RecordPublicKeyFailure(ctx context.Context, publicKey string, message string, backoff, maxBackoff time.Duration) (uint64, error)
+	ResetPublicKeyFailures(ctx context.Context, publicKey string) error
+	AcquirePublicKeyLease(ctx context.Context, publicKey, owner string, ttl time.Duration) (bool, error)
+	ReleasePublicKeyLease(ctx context.Context, publicKey, owner string) error
+
+	SyncFees(ctx context.Context, publicKey string, fees []*vtypes.Fee) error
+	GetPendingFees(ctx context.Context, publicKey string) ([]types.Fee, error)
+
+	CreateFeeRun(ctx context.Context, draft types.FeeRun) (*types.FeeRun, error)
+	CreateSkippedFeeRun(ctx context.Context, publicKey string, chain common.Chain, amount uint64, feeIDs []uint64, errorCode string, reason string) (*types.FeeRun, error)
+	SetFeeRunSigned(ctx context.Context, id uuid.UUID, attempt types.FeeRunAttempt) error
+	SetFeeRunSent(ctx context.Context, id uuid.UUID, txHash string, endpoint string) error
+	SetFeeRunCompleted(ctx context.Context, id uuid.UUID, txHash string) error
+	SetFeeRunFailed(ctx context.Context, id uuid.UUID, errorCode string, errorMessage string) error
+	SettleFeeRuns(ctx context.Context, publicKey string, feeIDs []uint64) error
+	GetFeeRun(ctx context.Context, id uuid.UUID) (*types.FeeRun, error)
+	GetFeeRunsByPublicKey(ctx context.Context, publicKey string, from, to time.Time) ([]*types.FeeRun, error)
+	GetFeeRunsByStatus(ctx context.Context, status types.FeeRunState) ([]*types.FeeRun, error)
+	GetUnsettledFeeRuns(ctx context.Context, publicKey string) ([]*types.FeeRun, error)
+	HasActiveFeeRun(ctx context.Context, publicKey string) (bool, error)
+	GetUnsentFeeRun(ctx context.Context, publicKey string) (*types.FeeRun, error)
+	GetFeeRunTransitions(ctx context.Context, id uuid.UUID) ([]types.FeeRunTransition, error)
+
+	AddFeeRunAttempt(ctx context.Context, id uuid.UUID, attempt types.FeeRunAttempt) error
+	SetFeeRunAttemptEndpoint(ctx context.Context, id uuid.UUID, txHash string, endpoint string) error
+	DeleteFeeRunAttempt(ctx context.Context, id uuid.UUID, txHash string) error
+	GetFeeRunAttempts(ctx context.Context, id uuid.UUID) ([]types.FeeRunAttempt, error)
+
+	TryAdvisoryLock(ctx context.Context, name string) (func(), bool, error)
 
 	Pool() *pgxpool.Pool
 }
@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/vultisig/feeplugin/internal/types"
+)
+
+// AddFeeRunAttempt records a replacement tx of a sent run, which becomes the tx of the run. It is recorded
+// before it is broadcasted, the endpoint is set once it is.
+func (p *PostgresBackend) AddFeeRunAttempt(ctx context.Context, id uuid.UUID, attempt types.FeeRunAttempt) error {
+	return p.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		err := updateFeeRunStatus(ctx, tx, id, types.FeeRunStateSent, &attempt.TxHash, nil)
+		if err != nil {
+			return err
+		}
+		return insertFeeRunAttempt(ctx, tx, id, attempt)
+	})
+}
+
+// SetFeeRunAttemptEndpoint records the endpoint which accepted the tx of the attempt
+func (p *PostgresBackend) SetFeeRunAttemptEndpoint(ctx context.Context, id uuid.UUID, txHash string, endpoint string) error {
+	return p.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		return updateFeeRunAttemptEndpoint(ctx, tx, id, txHash, endpoint)
+	})
+}
+
+// DeleteFeeRunAttempt removes a replacement tx the node rejected, the latest attempt left becomes the
+// tx of the run again
+func (p *PostgresBackend) DeleteFeeRunAttempt(ctx context.Context, id uuid.UUID, txHash string) error {
+	return p.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM fee_run_attempts WHERE fee_run_id = $1 AND tx_hash = $2`, id, txHash)
+		if err != nil {
+			return fmt.Errorf("failed to delete fee run attempt: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE fee_runs
+			SET tx_hash = (
+				SELECT tx_hash FROM fee_run_attempts
+				WHERE fee_run_id = $1
+				ORDER BY created_at DESC
+				LIMIT 1
+			), updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND tx_hash = $2`,
+			id, txHash,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to restore fee run tx hash: %w", err)
+		}
+		return nil
+	})
+}
+
+func (p *PostgresBackend) GetFeeRunAttempts(ctx context.Context, id uuid.UUID) ([]types.FeeRunAttempt, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT id, fee_run_id, kind, nonce, tx_hash, unsigned_tx, signed_tx, endpoint, created_at
+		FROM fee_run_attempts
+		WHERE fee_run_id = $1
+		ORDER BY created_at`,
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fee run attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []types.FeeRunAttempt
+	for rows.Next() {
+		var (
+			a     types.FeeRunAttempt
+			nonce int64
+		)
+		err := rows.Scan(&a.ID, &a.FeeRunID, &a.Kind, &nonce, &a.TxHash, &a.UnsignedTx, &a.SignedTx, &a.Endpoint, &a.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan fee run attempt: %w", err)
+		}
+		a.Nonce = uint64(nonce)
+		attempts = append(attempts, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over fee run attempts: %w", err)
+	}
+
+	return attempts, nil
+}
+
+func insertFeeRunAttempt(ctx context.Context, tx pgx.Tx, id uuid.UUID, attempt types.FeeRunAttempt) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO fee_run_attempts (fee_run_id, kind, nonce, tx_hash, unsigned_tx, signed_tx, endpoint)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id, attempt.Kind, int64(attempt.Nonce), attempt.TxHash, attempt.UnsignedTx, attempt.SignedTx, attempt.Endpoint,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert fee run attempt: %w", err)
+	}
+	return nil
+}
+
+func updateFeeRunAttemptEndpoint(ctx context.Context, tx pgx.Tx, id uuid.UUID, txHash string, endpoint string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE fee_run_attempts SET endpoint = $3
+		WHERE fee_run_id = $1 AND tx_hash = $2`,
+		id, txHash, endpoint,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set fee run attempt endpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,454 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/vultisig/vultisig-go/common"
+
+	"github.com/vultisig/feeplugin/internal/types"
+)
+
+const feeRunColumns = `id, public_key, chain, status, total_amount, method, asset, asset_amount, partial, settled_at, fee_ids, batch_key, debits, credits, net, tx_hash, error_message, error_code, created_at, updated_at`
+
+// CreateFeeRun creates a draft fee run from the given one and makes it the holder of its fees. A
+// partial run is an instalment which collects only part of the debt of the fees.
+func (p *PostgresBackend) CreateFeeRun(ctx context.Context, draft types.FeeRun) (*types.FeeRun, error) {
+	var run *types.FeeRun
+	err := p.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `
+			INSERT INTO fee_runs (
+				public_key, chain, status, total_amount, method, asset, asset_amount, partial, fee_ids, batch_key,
+				debits, credits, net
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			RETURNING `+feeRunColumns,
+			draft.PublicKey, draft.Chain, types.FeeRunStateDraft, int64(draft.TotalAmount),
+			draft.Method, draft.Asset, draft.AssetAmount, draft.Partial, toInt64s(draft.FeeIDs), draft.BatchKey,
+			draft.Debits, draft.Credits, draft.Net,
+		)
+		r, err := scanFeeRun(row)
+		if err != nil {
+			return fmt.Errorf("failed to insert fee run: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE fees SET fee_run_id = $1 WHERE fee_id = ANY($2)`, r.ID, toInt64s(draft.FeeIDs))
+		if err != nil {
+			return fmt.Errorf("failed to assign fees to fee run: %w", err)
+		}
+
+		err = insertFeeRunTransition(ctx, tx, r.ID, r.Status, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		r.FeeCount = len(draft.FeeIDs)
+		run = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// CreateSkippedFeeRun records a collection which was not attempted, the fees stay pending. errorCode is
+// the code of the TransactionError the collection was skipped on, empty if it was not one.
+func (p *PostgresBackend) CreateSkippedFeeRun(
+	ctx context.Context,
+	publicKey string,
+	chain common.Chain,
+	amount uint64,
+	feeIDs []uint64,
+	errorCode string,
+	reason string,
+) (*types.FeeRun, error) {
+	var code *string
+	if errorCode != "" {
+		code = &errorCode
+	}
+
+	var run *types.FeeRun
+	err := p.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `
+			INSERT INTO fee_runs (public_key, chain, status, total_amount, fee_ids, error_message, error_code)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING `+feeRunColumns,
+			publicKey, chain.String(), types.FeeRunStateSkipped, int64(amount), toInt64s(feeIDs), reason, code,
+		)
+		r, err := scanFeeRun(row)
+		if err != nil {
+			return fmt.Errorf("failed to insert fee run: %w", err)
+		}
+
+		err = insertFeeRunTransition(ctx, tx, r.ID, r.Status, nil, &reason)
+		if err != nil {
+			return err
+		}
+
+		r.FeeCount = len(feeIDs)
+		run = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// SetFeeRunSigned records the original attempt as the tx of the run, before it is broadcasted
+func (p *PostgresBackend) SetFeeRunSigned(ctx context.Context, id uuid.UUID, attempt types.FeeRunAttempt) error {
+	return p.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		err := updateFeeRunStatus(ctx, tx, id, types.FeeRunStateSigned, &attempt.TxHash, nil)
+		if err != nil {
+			return err
+		}
+		return insertFeeRunAttempt(ctx, tx, id, attempt)
+	})
+}
+
+// SetFeeRunSent moves the signed run to sent once its tx is broadcasted, endpoint is recorded on the
+// attempt of the tx unless it is empty
+func (p *PostgresBackend) SetFeeRunSent(ctx context.Context, id uuid.UUID, txHash string, endpoint string) error {
+	return p.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		err := updateFeeRunStatus(ctx, tx, id, types.FeeRunStateSent, &txHash, nil)
+		if err != nil {
+			return err
+		}
+		if endpoint == "" {
+			return nil
+		}
+		return updateFeeRunAttemptEndpoint(ctx, tx, id, txHash, endpoint)
+	})
+}
+
+// SetFeeRunCompleted completes the run. The fees of a partial run are released, so the rest of the
+// debt is collected by the next run. A full run settles itself and every unsettled partial run of
+// the public key, as its fees have been reported to the verifier as collected.
+// txHash is the attempt which was mined.
+func (p *PostgresBackend) SetFeeRunCompleted(ctx context.Context, id uuid.UUID, txHash string) error {
+	return p.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		var (
+			publicKey string
+			partial   bool
+		)
+		err := tx.QueryRow(ctx, `
+			UPDATE fee_runs
+			SET status = $2, tx_hash = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+			RETURNING public_key, partial`,
+			id, types.FeeRunStateSuccess, txHash,
+		).Scan(&publicKey, &partial)
+		if err != nil {
+			return fmt.Errorf("failed to update fee run status: %w", err)
+		}
+
+		if partial {
+			_, err = tx.Exec(ctx, `UPDATE fees SET fee_run_id = NULL WHERE fee_run_id = $1`, id)
+			if err != nil {
+				return fmt.Errorf("failed to release fees: %w", err)
+			}
+		} else {
+			err = settleFeeRuns(ctx, tx, id, publicKey)
+			if err != nil {
+				return err
+			}
+		}
+
+		return insertFeeRunTransition(ctx, tx, id, types.FeeRunStateSuccess, &txHash, nil)
+	})
+}
+
+// SettleFeeRuns settles the unsettled partial runs of the public key when they already cover the debt
+// of the fees, the fees are assigned to the latest of those runs
+func (p *PostgresBackend) SettleFeeRuns(ctx context.Context, publicKey string, feeIDs []uint64) error {
+	return p.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		var lastID uuid.UUID
+		err := tx.QueryRow(ctx, `
+			SELECT id FROM fee_runs
+			WHERE public_key = $1 AND status = $2 AND partial AND settled_at IS NULL
+			ORDER BY created_at DESC
+			LIMIT 1`,
+			publicKey, types.FeeRunStateSuccess,
+		).Scan(&lastID)
+		if err != nil {
+			return fmt.Errorf("failed to get last partial fee run: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE fees SET fee_run_id = $1 WHERE fee_id = ANY($2)`, lastID, toInt64s(feeIDs))
+		if err != nil {
+			return fmt.Errorf("failed to assign fees to fee run: %w", err)
+		}
+
+		return settleFeeRuns(ctx, tx, lastID, publicKey)
+	})
+}
+
+func settleFeeRuns(ctx context.Context, tx pgx.Tx, id uuid.UUID, publicKey string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE fee_runs
+		SET settled_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE settled_at IS NULL
+		  AND (id = $1 OR (public_key = $2 AND status = $3 AND partial))`,
+		id, publicKey, types.FeeRunStateSuccess,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to settle fee runs: %w", err)
+	}
+	return nil
+}
+
+// SetFeeRunFailed fails the run and releases its fees, so they are picked up by the next run. errorCode
+// is the code of the TransactionError the run stopped on, empty if it was not one.
+func (p *PostgresBackend) SetFeeRunFailed(ctx context.Context, id uuid.UUID, errorCode string, errorMessage string) error {
+	return p.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		err := updateFeeRunStatus(ctx, tx, id, types.FeeRunStateFailed, nil, &errorMessage)
+		if err != nil {
+			return err
+		}
+		if errorCode == "" {
+			return nil
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE fee_runs SET error_code = $2 WHERE id = $1`, id, errorCode)
+		if err != nil {
+			return fmt.Errorf("failed to set fee run error code: %w", err)
+		}
+		return nil
+	})
+}
+
+func updateFeeRunStatus(
+	ctx context.Context,
+	tx pgx.Tx,
+	id uuid.UUID,
+	status types.FeeRunState,
+	txHash *string,
+	errorMessage *string,
+) error {
+	tag, err := tx.Exec(ctx, `
+		UPDATE fee_runs
+		SET status = $2,
+		    tx_hash = COALESCE($3, tx_hash),
+		    error_message = COALESCE($4, error_message),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		id, status, txHash, errorMessage,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update fee run status: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("fee run not found: %s", id)
+	}
+
+	if status == types.FeeRunStateFailed {
+		_, err = tx.Exec(ctx, `UPDATE fees SET fee_run_id = NULL WHERE fee_run_id = $1`, id)
+		if err != nil {
+			return fmt.Errorf("failed to release fees: %w", err)
+		}
+	}
+
+	return insertFeeRunTransition(ctx, tx, id, status, txHash, errorMessage)
+}
+
+func (p *PostgresBackend) GetFeeRun(ctx context.Context, id uuid.UUID) (*types.FeeRun, error) {
+	row := p.pool.QueryRow(ctx, `SELECT `+feeRunColumns+` FROM fee_runs WHERE id = $1`, id)
+	run, err := scanFeeRun(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fee run: %w", err)
+	}
+
+	fees, err := p.getFeesByIDs(ctx, run.FeeIDs)
+	if err != nil {
+		return nil, err
+	}
+	run.Fees = fees
+	run.FeeCount = len(run.FeeIDs)
+
+	return run, nil
+}
+
+func (p *PostgresBackend) GetFeeRunsByPublicKey(
+	ctx context.Context,
+	publicKey string,
+	from, to time.Time,
+) ([]*types.FeeRun, error) {
+	return p.queryFeeRuns(ctx, `
+		SELECT `+feeRunColumns+`
+		FROM fee_runs
+		WHERE public_key = $1 AND created_at >= $2 AND created_at < $3
+		ORDER BY created_at`,
+		publicKey, from, to,
+	)
+}
+
+func (p *PostgresBackend) GetFeeRunsByStatus(ctx context.Context, status types.FeeRunState) ([]*types.FeeRun, error) {
+	return p.queryFeeRuns(ctx, `
+		SELECT `+feeRunColumns+`
+		FROM fee_runs
+		WHERE status = $1
+		ORDER BY created_at`,
+		status,
+	)
+}
+
+// GetUnsettledFeeRuns returns the completed instalments of the public key whose fees are not reported as collected yet
+func (p *PostgresBackend) GetUnsettledFeeRuns(ctx context.Context, publicKey string) ([]*types.FeeRun, error) {
+	return p.queryFeeRuns(ctx, `
+		SELECT `+feeRunColumns+`
+		FROM fee_runs
+		WHERE public_key = $1 AND status = $2 AND partial AND settled_at IS NULL
+		ORDER BY created_at`,
+		publicKey, types.FeeRunStateSuccess,
+	)
+}
+
+// HasActiveFeeRun reports whether the public key has a run which is not finished yet
+func (p *PostgresBackend) HasActiveFeeRun(ctx context.Context, publicKey string) (bool, error) {
+	var exists bool
+	err := p.pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM fee_runs WHERE public_key = $1 AND status IN ($2, $3, $4))`,
+		publicKey, types.FeeRunStateDraft, types.FeeRunStateSigned, types.FeeRunStateSent,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check active fee runs: %w", err)
+	}
+	return exists, nil
+}
+
+// GetUnsentFeeRun returns the draft or signed run of the public key, left behind by a worker which stopped
+// before broadcasting its tx, or nil if there is none
+func (p *PostgresBackend) GetUnsentFeeRun(ctx context.Context, publicKey string) (*types.FeeRun, error) {
+	runs, err := p.queryFeeRuns(ctx, `
+		SELECT `+feeRunColumns+`
+		FROM fee_runs
+		WHERE public_key = $1 AND status IN ($2, $3)
+		ORDER BY created_at
+		LIMIT 1`,
+		publicKey, types.FeeRunStateDraft, types.FeeRunStateSigned,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, nil
+	}
+	return runs[0], nil
+}
+
+func (p *PostgresBackend) queryFeeRuns(ctx context.Context, query string, args ...any) ([]*types.FeeRun, error) {
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fee runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []*types.FeeRun
+	for rows.Next() {
+		run, err := scanFeeRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan fee run: %w", err)
+		}
+		run.FeeCount = len(run.FeeIDs)
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over fee runs: %w", err)
+	}
+
+	return runs, nil
+}
+
+func (p *PostgresBackend) GetFeeRunTransitions(ctx context.Context, id uuid.UUID) ([]types.FeeRunTransition, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT fee_run_id, status, tx_hash, error_message, created_at
+		FROM fee_run_transitions
+		WHERE fee_run_id = $1
+		ORDER BY id`,
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fee run transitions: %w", err)
+	}
+	defer rows.Close()
+
+	var transitions []types.FeeRunTransition
+	for rows.Next() {
+		var t types.FeeRunTransition
+		if err := rows.Scan(&t.FeeRunID, &t.Status, &t.TxHash, &t.ErrorMessage, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan fee run transition: %w", err)
+		}
+		transitions = append(transitions, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over fee run transitions: %w", err)
+	}
+
+	return transitions, nil
+}
+
+func insertFeeRunTransition(
+	ctx context.Context,
+	tx pgx.Tx,
+	id uuid.UUID,
+	status types.FeeRunState,
+	txHash *string,
+	errorMessage *string,
+) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO fee_run_transitions (fee_run_id, status, tx_hash, error_message)
+		VALUES ($1, $2, $3, $4)`,
+		id, status, txHash, errorMessage,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert fee run transition: %w", err)
+	}
+	return nil
+}
+
+func scanFeeRun(row pgx.Row) (*types.FeeRun, error) {
+	var (
+		run         types.FeeRun
+		totalAmount int64
+		feeIDs      []int64
+	)
+	err := row.Scan(
+		&run.ID,
+		&run.PublicKey,
+		&run.Chain,
+		&run.Status,
+		&totalAmount,
+		&run.Method,
+		&run.Asset,
+		&run.AssetAmount,
+		&run.Partial,
+		&run.SettledAt,
+		&feeIDs,
+		&run.BatchKey,
+		&run.Debits,
+		&run.Credits,
+		&run.Net,
+		&run.TxHash,
+		&run.ErrorMessage,
+		&run.ErrorCode,
+		&run.CreatedAt,
+		&run.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	run.TotalAmount = uint64(totalAmount)
+	for _, id := range feeIDs {
+		run.FeeIDs = append(run.FeeIDs, uint64(id))
+	}
+	return &run, nil
+}
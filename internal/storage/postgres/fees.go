@@ -1,13 +1,24 @@
 package postgres
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	vtypes "github.com/vultisig/verifier/types"
+
+	"github.com/vultisig/feeplugin/internal/types"
+)
+
+// GetPublicKeys returns the public keys fees are loaded for, those not dead-lettered and due for a retry
 func (p *PostgresBackend) GetPublicKeys(ctx context.Context) ([]string, error) {
-	query := `SELECT public_key FROM plugin_keys` // ordered by creation time for consistency
+	query := `SELECT public_key FROM plugin_keys
+		WHERE status != $1 AND (next_retry_at IS NULL OR next_retry_at <= CURRENT_TIMESTAMP)` // ordered by creation time for consistency
 
 	var publicKeys []string
 
-	rows, err := p.pool.Query(ctx, query)
+	rows, err := p.pool.Query(ctx, query, types.PublicKeyStatusDeadLetter)
 	if err != nil {
 		return nil, err // propagate database query error
 	}
@@ -28,6 +39,109 @@ func (p *PostgresBackend) GetPublicKeys(ctx context.Context) ([]string, error) {
 	return publicKeys, nil
 }
 
+// GetPublicKeysByStatus returns the public keys with the status which are due for a retry
+func (p *PostgresBackend) GetPublicKeysByStatus(ctx context.Context, status types.PublicKeyStatus) ([]string, error) {
+	rows, err := p.pool.Query(ctx, `SELECT public_key FROM plugin_keys
+		WHERE status = $1 AND (next_retry_at IS NULL OR next_retry_at <= CURRENT_TIMESTAMP)`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var publicKeys []string
+	for rows.Next() {
+		var publicKey string
+		if err := rows.Scan(&publicKey); err != nil {
+			return nil, err
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return publicKeys, nil
+}
+
+// GetHeldPublicKeys returns the public keys the fees:transaction stage leaves out, those which are not
+// active and those backing off after a failure
+func (p *PostgresBackend) GetHeldPublicKeys(ctx context.Context) ([]*types.PublicKey, error) {
+	rows, err := p.pool.Query(ctx, `SELECT public_key, status, status_reason, failed_attempts, next_retry_at, last_error
+		FROM plugin_keys
+		WHERE status != $1 OR next_retry_at > CURRENT_TIMESTAMP
+		ORDER BY created_at`, types.PublicKeyStatusActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*types.PublicKey
+	for rows.Next() {
+		var k types.PublicKey
+		var attempts int64
+		err := rows.Scan(&k.PublicKey, &k.Status, &k.StatusReason, &attempts, &k.NextRetryAt, &k.LastError)
+		if err != nil {
+			return nil, err
+		}
+		k.FailedAttempts = uint64(attempts)
+		keys = append(keys, &k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (p *PostgresBackend) SetPublicKeyStatus(ctx context.Context, publicKey string, status types.PublicKeyStatus, reason string) error {
+	query := `UPDATE plugin_keys SET status = $2, status_reason = $3, updated_at = CURRENT_TIMESTAMP WHERE public_key = $1`
+
+	_, err := p.pool.Exec(ctx, query, publicKey, status, reason)
+	if err != nil {
+		return fmt.Errorf("failed to set public key status: %w", err)
+	}
+
+	return nil
+}
+
+// RecordPublicKeyFailure counts a failure of the vault and pushes its next retry back exponentially, from
+// backoff up to maxBackoff. It returns the consecutive failures so far.
+func (p *PostgresBackend) RecordPublicKeyFailure(
+	ctx context.Context,
+	publicKey string,
+	message string,
+	backoff, maxBackoff time.Duration,
+) (uint64, error) {
+	query := `UPDATE plugin_keys SET
+		failed_attempts = failed_attempts + 1,
+		next_retry_at = CURRENT_TIMESTAMP + LEAST($3 * POWER(2, failed_attempts), $4) * INTERVAL '1 second',
+		last_error = $2,
+		updated_at = CURRENT_TIMESTAMP
+		WHERE public_key = $1
+		RETURNING failed_attempts`
+
+	var attempts uint64
+	err := p.pool.QueryRow(ctx, query, publicKey, message, backoff.Seconds(), maxBackoff.Seconds()).Scan(&attempts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record public key failure: %w", err)
+	}
+	return attempts, nil
+}
+
+// ResetPublicKeyFailures clears the failures of the vault once it is handled successfully
+func (p *PostgresBackend) ResetPublicKeyFailures(ctx context.Context, publicKey string) error {
+	query := `UPDATE plugin_keys SET failed_attempts = 0, next_retry_at = NULL, last_error = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE public_key = $1 AND failed_attempts > 0`
+
+	_, err := p.pool.Exec(ctx, query, publicKey)
+	if err != nil {
+		return fmt.Errorf("failed to reset public key failures: %w", err)
+	}
+	return nil
+}
+
 func (p *PostgresBackend) InsertPublicKey(ctx context.Context, publicKey string) error {
 	query := `INSERT INTO plugin_keys (public_key) VALUES ($1) ON CONFLICT (public_key) DO NOTHING`
 
@@ -38,3 +152,97 @@ func (p *PostgresBackend) InsertPublicKey(ctx context.Context, publicKey string)
 
 	return nil
 }
+
+// SyncFees stores the fees the verifier reports for the public key. Local fees which are no
+// longer reported and are not reserved by a fee run are dropped.
+func (p *PostgresBackend) SyncFees(ctx context.Context, publicKey string, fees []*vtypes.Fee) error {
+	feeIDs := make([]int64, 0, len(fees))
+	for _, fee := range fees {
+		feeIDs = append(feeIDs, int64(fee.ID))
+	}
+
+	return p.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		for _, fee := range fees {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO fees (fee_id, public_key, policy_id, tx_type, amount, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (fee_id) DO NOTHING`,
+				int64(fee.ID), publicKey, fee.PolicyID, string(fee.TxType), int64(fee.Amount), fee.CreatedAt,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to insert fee %d: %w", fee.ID, err)
+			}
+		}
+
+		_, err := tx.Exec(ctx, `
+			DELETE FROM fees
+			WHERE public_key = $1 AND fee_run_id IS NULL AND NOT (fee_id = ANY($2))`,
+			publicKey, feeIDs,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to delete stale fees: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetPendingFees returns the fees of the public key which are not reserved by a fee run
+func (p *PostgresBackend) GetPendingFees(ctx context.Context, publicKey string) ([]types.Fee, error) {
+	return p.queryFees(ctx, `
+		SELECT `+feeColumns+`
+		FROM fees f
+		WHERE f.public_key = $1 AND f.fee_run_id IS NULL
+		ORDER BY f.fee_id`,
+		publicKey,
+	)
+}
+
+func (p *PostgresBackend) getFeesByIDs(ctx context.Context, feeIDs []uint64) ([]types.Fee, error) {
+	return p.queryFees(ctx, `
+		SELECT `+feeColumns+`
+		FROM fees f
+		WHERE f.fee_id = ANY($1)
+		ORDER BY f.fee_id`,
+		toInt64s(feeIDs),
+	)
+}
+
+const feeColumns = `f.id, f.fee_id, f.fee_run_id, f.public_key, f.policy_id, f.tx_type, f.amount, f.created_at`
+
+func (p *PostgresBackend) queryFees(ctx context.Context, query string, args ...any) ([]types.Fee, error) {
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fees: %w", err)
+	}
+	defer rows.Close()
+
+	var fees []types.Fee
+	for rows.Next() {
+		var (
+			fee    types.Fee
+			feeID  int64
+			amount int64
+		)
+		err := rows.Scan(&fee.ID, &feeID, &fee.FeeRunID, &fee.PublicKey, &fee.PolicyID, &fee.TxType, &amount, &fee.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan fee: %w", err)
+		}
+		fee.FeeID = uint64(feeID)
+		fee.Amount = uint64(amount)
+		fees = append(fees, fee)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over fees: %w", err)
+	}
+
+	return fees, nil
+}
+
+func toInt64s(in []uint64) []int64 {
+	out := make([]int64, 0, len(in))
+	for _, v := range in {
+		out = append(out, int64(v))
+	}
+	return out
+}
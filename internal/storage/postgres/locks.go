@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TryAdvisoryLock takes the session advisory lock of name without waiting, it reports false if another
+// session holds it. The lock is held on a dedicated connection of the pool until release is called, or
+// until the connection drops with the replica holding it.
+func (p *PostgresBackend) TryAdvisoryLock(ctx context.Context, name string) (func(), bool, error) {
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	var locked bool
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtextextended($1, 0))`, name).Scan(&locked)
+	if err != nil {
+		conn.Release()
+		return nil, false, fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+	if !locked {
+		conn.Release()
+		return nil, false, nil
+	}
+
+	release := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		_, err := conn.Exec(ctx, `SELECT pg_advisory_unlock(hashtextextended($1, 0))`, name)
+		if err != nil {
+			// the lock goes with the session, never hand a connection still holding it back to the pool
+			p.logger.WithError(err).WithField("lock", name).Error("failed to release advisory lock, closing connection")
+			_ = conn.Conn().Close(ctx)
+		}
+		conn.Release()
+	}
+	return release, true, nil
+}
+
+// AcquirePublicKeyLease leases the vault to owner for ttl, unless it is already leased. The lease is not
+// reentrant, so concurrent tasks of the same replica don't process the vault twice either.
+func (p *PostgresBackend) AcquirePublicKeyLease(ctx context.Context, publicKey, owner string, ttl time.Duration) (bool, error) {
+	query := `UPDATE plugin_keys SET lease_owner = $2, lease_until = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second'
+		WHERE public_key = $1 AND (lease_owner IS NULL OR lease_until < CURRENT_TIMESTAMP)`
+
+	tag, err := p.pool.Exec(ctx, query, publicKey, owner, ttl.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire public key lease: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReleasePublicKeyLease gives the lease of the vault up, if owner still holds it
+func (p *PostgresBackend) ReleasePublicKeyLease(ctx context.Context, publicKey, owner string) error {
+	query := `UPDATE plugin_keys SET lease_owner = NULL, lease_until = NULL WHERE public_key = $1 AND lease_owner = $2`
+
+	_, err := p.pool.Exec(ctx, query, publicKey, owner)
+	if err != nil {
+		return fmt.Errorf("failed to release public key lease: %w", err)
+	}
+	return nil
+}
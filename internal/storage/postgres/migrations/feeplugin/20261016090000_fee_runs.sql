@@ -0,0 +1,56 @@
+-- +goose Up
+-- +goose StatementBegin
+BEGIN;
+
+CREATE TABLE fee_runs (
+                          id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                          public_key VARCHAR(255) NOT NULL,
+                          chain VARCHAR(64) NOT NULL,
+                          status VARCHAR(32) NOT NULL DEFAULT 'draft',
+                          total_amount BIGINT NOT NULL,
+                          -- verifier fee ids covered by this attempt, kept even if the fees are picked up by a later run
+                          fee_ids BIGINT[] NOT NULL DEFAULT '{}',
+                          tx_hash VARCHAR(255),
+                          error_message TEXT,
+                          created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                          updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_fee_runs_public_key_created_at ON fee_runs(public_key, created_at);
+CREATE INDEX idx_fee_runs_status ON fee_runs(status);
+
+CREATE TABLE fees (
+                      id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                      -- id of the fee on the verifier side
+                      fee_id BIGINT NOT NULL,
+                      fee_run_id UUID REFERENCES fee_runs(id),
+                      public_key VARCHAR(255) NOT NULL,
+                      policy_id UUID NOT NULL,
+                      tx_type VARCHAR(32) NOT NULL,
+                      amount BIGINT NOT NULL,
+                      created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE fees ADD CONSTRAINT unique_fee_id UNIQUE (fee_id);
+CREATE INDEX idx_fees_fee_run_id ON fees(fee_run_id);
+CREATE INDEX idx_fees_public_key ON fees(public_key);
+
+-- every status change of a fee run, so the history of an attempt can be reconstructed
+CREATE TABLE fee_run_transitions (
+                                     id BIGSERIAL PRIMARY KEY,
+                                     fee_run_id UUID NOT NULL REFERENCES fee_runs(id) ON DELETE CASCADE,
+                                     status VARCHAR(32) NOT NULL,
+                                     tx_hash VARCHAR(255),
+                                     error_message TEXT,
+                                     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_fee_run_transitions_fee_run_id ON fee_run_transitions(fee_run_id, created_at);
+
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS fee_run_transitions;
+DROP TABLE IF EXISTS fees;
+DROP TABLE IF EXISTS fee_runs;
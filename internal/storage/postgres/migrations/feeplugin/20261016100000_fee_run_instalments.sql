@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+BEGIN;
+
+-- an instalment run collects less than the outstanding debt, its fees are released once it is completed
+ALTER TABLE fee_runs ADD COLUMN partial BOOLEAN NOT NULL DEFAULT false;
+-- set once the fees of the run are reported to the verifier as collected
+ALTER TABLE fee_runs ADD COLUMN settled_at TIMESTAMP;
+CREATE INDEX idx_fee_runs_public_key_status ON fee_runs(public_key, status);
+
+ALTER TABLE plugin_keys ADD COLUMN status VARCHAR(32) NOT NULL DEFAULT 'active';
+ALTER TABLE plugin_keys ADD COLUMN status_reason TEXT;
+ALTER TABLE plugin_keys ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE plugin_keys DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE plugin_keys DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE plugin_keys DROP COLUMN IF EXISTS status;
+DROP INDEX IF EXISTS idx_fee_runs_public_key_status;
+ALTER TABLE fee_runs DROP COLUMN IF EXISTS settled_at;
+ALTER TABLE fee_runs DROP COLUMN IF EXISTS partial;
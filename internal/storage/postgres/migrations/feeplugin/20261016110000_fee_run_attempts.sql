@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+BEGIN;
+
+-- every tx signed for a fee run: the original transfer and the speed-up or cancel replacements sharing its nonce
+CREATE TABLE fee_run_attempts (
+                                  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                                  fee_run_id UUID NOT NULL REFERENCES fee_runs(id) ON DELETE CASCADE,
+                                  kind VARCHAR(32) NOT NULL,
+                                  nonce BIGINT NOT NULL,
+                                  tx_hash VARCHAR(255) NOT NULL,
+                                  -- base64 unsigned tx, replacements are derived from the latest one
+                                  unsigned_tx TEXT NOT NULL,
+                                  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_fee_run_attempts_fee_run_id ON fee_run_attempts(fee_run_id, created_at);
+
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS fee_run_attempts;
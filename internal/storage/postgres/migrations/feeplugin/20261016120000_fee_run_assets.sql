@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+BEGIN;
+
+-- what the run transfers: total_amount stays the debt in USDC base units, a run may pay it in another asset
+ALTER TABLE fee_runs ADD COLUMN asset VARCHAR(255) NOT NULL DEFAULT '';
+-- in base units of the asset, as text since wei amounts do not fit a BIGINT
+ALTER TABLE fee_runs ADD COLUMN asset_amount VARCHAR(78) NOT NULL DEFAULT '0';
+
+-- runs before this migration were USDC transfers on Ethereum. The backfill is mainnet only, it fills in
+-- mainnet USDC: a testnet deployment gets that address on its old runs, runs of other chains are left empty
+UPDATE fee_runs SET asset = '0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48', asset_amount = total_amount::TEXT
+WHERE chain = 'Ethereum';
+
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE fee_runs DROP COLUMN IF EXISTS asset_amount;
+ALTER TABLE fee_runs DROP COLUMN IF EXISTS asset;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+BEGIN;
+
+-- how the run collects: a transfer of the asset to the treasury, or a swap of the asset to USDC received by the treasury
+ALTER TABLE fee_runs ADD COLUMN method VARCHAR(32) NOT NULL DEFAULT 'transfer';
+
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE fee_runs DROP COLUMN IF EXISTS method;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+BEGIN;
+
+-- consecutive failures of the vault, it is skipped until next_retry_at and dead-lettered once they pile up
+ALTER TABLE plugin_keys ADD COLUMN failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE plugin_keys ADD COLUMN next_retry_at TIMESTAMP;
+ALTER TABLE plugin_keys ADD COLUMN last_error TEXT;
+
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE plugin_keys DROP COLUMN IF EXISTS last_error;
+ALTER TABLE plugin_keys DROP COLUMN IF EXISTS next_retry_at;
+ALTER TABLE plugin_keys DROP COLUMN IF EXISTS failed_attempts;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+BEGIN;
+
+-- the worker replica collecting the vault, until lease_until. An expired lease is free to take over.
+ALTER TABLE plugin_keys ADD COLUMN lease_owner VARCHAR(64);
+ALTER TABLE plugin_keys ADD COLUMN lease_until TIMESTAMP;
+
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE plugin_keys DROP COLUMN IF EXISTS lease_until;
+ALTER TABLE plugin_keys DROP COLUMN IF EXISTS lease_owner;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+BEGIN;
+
+-- sha256 of the sorted fee ids of the run, at most one unfinished run per batch of fees
+ALTER TABLE fee_runs ADD COLUMN batch_key VARCHAR(64);
+CREATE UNIQUE INDEX idx_fee_runs_batch_key_active ON fee_runs(batch_key) WHERE status IN ('draft', 'signed', 'sent');
+
+-- base64 signed tx, stored before it is broadcasted so a worker restarting mid-run sends the same tx again
+ALTER TABLE fee_run_attempts ADD COLUMN signed_tx TEXT NOT NULL DEFAULT '';
+
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE fee_run_attempts DROP COLUMN IF EXISTS signed_tx;
+DROP INDEX IF EXISTS idx_fee_runs_batch_key_active;
+ALTER TABLE fee_runs DROP COLUMN IF EXISTS batch_key;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+BEGIN;
+
+-- debt of the fees of the run in USDC base units, as text since the sums are computed without overflow
+ALTER TABLE fee_runs ADD COLUMN debits VARCHAR(78) NOT NULL DEFAULT '0';
+ALTER TABLE fee_runs ADD COLUMN credits VARCHAR(78) NOT NULL DEFAULT '0';
+-- debits minus credits, may be negative
+ALTER TABLE fee_runs ADD COLUMN net VARCHAR(79) NOT NULL DEFAULT '0';
+
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE fee_runs DROP COLUMN IF EXISTS net;
+ALTER TABLE fee_runs DROP COLUMN IF EXISTS credits;
+ALTER TABLE fee_runs DROP COLUMN IF EXISTS debits;
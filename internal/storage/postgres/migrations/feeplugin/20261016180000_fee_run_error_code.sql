@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+BEGIN;
+
+-- code of the TransactionError a failed run stopped on, error_message holds the details
+ALTER TABLE fee_runs ADD COLUMN error_code VARCHAR(64);
+
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE fee_runs DROP COLUMN IF EXISTS error_code;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+BEGIN;
+
+-- host of the endpoint which accepted the tx of the attempt, per the broadcast strategy of its chain
+ALTER TABLE fee_run_attempts ADD COLUMN endpoint VARCHAR(255) NOT NULL DEFAULT '';
+
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE fee_run_attempts DROP COLUMN IF EXISTS endpoint;
@@ -1,15 +1,66 @@
 package types
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
+// BroadcastStrategy is how the signed fee txs of a chain reach the network
 type BroadcastStrategy string
 
+const (
+	BroadcastStrategySingle       BroadcastStrategy = "single"        // through the provider of the chain
+	BroadcastStrategyFanOut       BroadcastStrategy = "fan_out"       // through the provider and every endpoint at once, the first to accept wins
+	BroadcastStrategyPrivateRelay BroadcastStrategy = "private_relay" // through the relay only, the tx stays out of the public mempool
+)
+
+// codes of TransactionError, used as metric labels and stored on the failed and skipped fee runs
+const (
+	TxErrorVerifierUnavailable  = "verifier_unavailable"  // the verifier could not be reached, failed with a server error or rate limited the plugin
+	TxErrorVerifierRejected     = "verifier_rejected"     // the verifier refused the request of the vault with a client error
+	TxErrorVerifierUnauthorized = "verifier_unauthorized" // the verifier refused the token of the plugin
+	TxErrorPublicKeyUnknown     = "public_key_unknown"    // the verifier does not know the public key of the vault
+	TxErrorVaultMissing         = "vault_missing"         // the vault is unknown to the vault storage
+	TxErrorInsufficientBalance  = "insufficient_balance"  // the vault holds less than its debt and nothing was collected
+	TxErrorSimulationRevert     = "simulation_revert"     // the node rejected the simulated tx, it was never signed
+	TxErrorKeysignTimeout       = "keysign_timeout"       // the keysign session did not complete in time
+	TxErrorSignatureMismatch    = "signature_mismatch"    // the keysign signature is not one of the vault key, it was never broadcasted
+	TxErrorBroadcastRejected    = "broadcast_rejected"    // the node refused the signed tx
+	TxErrorReceiptReverted      = "receipt_reverted"      // the tx was mined and reverted
+	TxErrorInterrupted          = "interrupted"           // the worker stopped before the tx was signed
+	TxErrorTxCancelled          = "tx_cancelled"          // the cancel tx took the nonce of the stuck fee tx
+	TxErrorTxDropped            = "tx_dropped"            // no tx of the run was found on chain in time
+)
+
 type TransactionError struct {
 	Code    string
 	Message string
 	Err     error
 }
 
+// NewTransactionError wraps err with the code, its message is the one of err
+func NewTransactionError(code string, err error) *TransactionError {
+	return &TransactionError{
+		Code:    code,
+		Message: err.Error(),
+		Err:     err,
+	}
+}
+
 func (e *TransactionError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Code, e.Message)
 }
+
+func (e *TransactionError) Unwrap() error {
+	return e.Err
+}
+
+// TransactionErrorCode returns the code of the first TransactionError in the chain of err, empty if there
+// is none
+func TransactionErrorCode(err error) string {
+	var txErr *TransactionError
+	if errors.As(err, &txErr) {
+		return txErr.Code
+	}
+	return ""
+}
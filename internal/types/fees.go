@@ -12,28 +12,102 @@ type FeeRunState string
 
 const (
 	FeeRunStateDraft   FeeRunState = "draft"
+	FeeRunStateSigned  FeeRunState = "signed" // the tx is signed and stored, it may or may not have been broadcasted
 	FeeRunStateSent    FeeRunState = "sent"
 	FeeRunStateSuccess FeeRunState = "completed"
 	FeeRunStateFailed  FeeRunState = "failed"
+	FeeRunStateSkipped FeeRunState = "skipped" // nothing was sent, error_message holds the reason
 )
 
+type FeeRunMethod string
+
+const (
+	FeeRunMethodTransfer FeeRunMethod = "transfer"
+	FeeRunMethodSwap     FeeRunMethod = "swap" // the asset is swapped to USDC with the treasury as recipient
+)
+
+type FeeRunAttemptKind string
+
+const (
+	FeeRunAttemptOriginal FeeRunAttemptKind = "original"
+	FeeRunAttemptSpeedup  FeeRunAttemptKind = "speedup" // same tx with bumped fees
+	FeeRunAttemptCancel   FeeRunAttemptKind = "cancel"  // zero value transfer taking the nonce of the stuck tx
+)
+
+type PublicKeyStatus string
+
+const (
+	PublicKeyStatusActive     PublicKeyStatus = "active"
+	PublicKeyStatusReview     PublicKeyStatus = "review"      // flagged for manual review, fees are loaded but not collected
+	PublicKeyStatusDeadLetter PublicKeyStatus = "dead_letter" // kept failing, fees are neither loaded nor collected until an operator reactivates it
+)
+
+// plugin_keys table, the collection state of a vault
+type PublicKey struct {
+	PublicKey      string          `db:"public_key"`
+	Status         PublicKeyStatus `db:"status"`
+	StatusReason   *string         `db:"status_reason"`
+	FailedAttempts uint64          `db:"failed_attempts"`
+	NextRetryAt    *time.Time      `db:"next_retry_at"` // the vault is backing off until then
+	LastError      *string         `db:"last_error"`
+}
+
 // individual fee record in the db
 type Fee struct {
-	ID        uuid.UUID `db:"id"`
-	FeeRunID  uuid.UUID `db:"fee_run_id"`
-	Amount    int       `db:"amount"`
-	CreatedAt time.Time `db:"created_at"`
+	ID        uuid.UUID  `db:"id"`
+	FeeID     uint64     `db:"fee_id"` // id of the fee on the verifier side
+	FeeRunID  *uuid.UUID `db:"fee_run_id"`
+	PublicKey string     `db:"public_key"`
+	PolicyID  uuid.UUID  `db:"policy_id"`
+	TxType    string     `db:"tx_type"`
+	Amount    uint64     `db:"amount"`
+	CreatedAt time.Time  `db:"created_at"`
 }
 
-// fee table or fee_run_with_totals
+// fee_runs table, one row per collection attempt
 type FeeRun struct {
-	ID          uuid.UUID   `db:"id"`
-	Status      FeeRunState `db:"status"`
-	CreatedAt   time.Time   `db:"created_at"`
-	UpdatedAt   time.Time   `db:"updated_at"`
-	TxHash      *string     `db:"tx_hash"`
-	PolicyID    uuid.UUID   `db:"policy_id"`
-	TotalAmount int         `db:"total_amount"`
-	FeeCount    int         `db:"fee_count"`
-	Fees        []Fee       `db:"fees"`
+	ID           uuid.UUID    `db:"id"`
+	Status       FeeRunState  `db:"status"`
+	CreatedAt    time.Time    `db:"created_at"`
+	UpdatedAt    time.Time    `db:"updated_at"`
+	TxHash       *string      `db:"tx_hash"`
+	ErrorMessage *string      `db:"error_message"`
+	ErrorCode    *string      `db:"error_code"` // code of the TransactionError of a failed or skipped run
+	PublicKey    string       `db:"public_key"`
+	Chain        string       `db:"chain"`
+	TotalAmount  uint64       `db:"total_amount"` // debt collected by the run, in USDC base units
+	Method       FeeRunMethod `db:"method"`
+	Asset        string       `db:"asset"`        // token transferred, the zero address for the native coin
+	AssetAmount  string       `db:"asset_amount"` // in base units of the asset
+	Partial      bool         `db:"partial"`      // instalment of a debt larger than TotalAmount
+	SettledAt    *time.Time   `db:"settled_at"`
+	FeeIDs       []uint64     `db:"fee_ids"`
+	BatchKey     *string      `db:"batch_key"` // sha256 of the sorted fee ids, unset on skipped runs
+	Debits       string       `db:"debits"`    // sum of the debit fees of the run, in USDC base units
+	Credits      string       `db:"credits"`   // sum of the credit fees of the run, in USDC base units
+	Net          string       `db:"net"`       // debits minus credits, the whole debt of which TotalAmount is collected
+	FeeCount     int          `db:"fee_count"`
+	Fees         []Fee        `db:"fees"`
+}
+
+// fee_run_transitions table, one row per status change of a fee run
+type FeeRunTransition struct {
+	FeeRunID     uuid.UUID   `db:"fee_run_id"`
+	Status       FeeRunState `db:"status"`
+	TxHash       *string     `db:"tx_hash"`
+	ErrorMessage *string     `db:"error_message"`
+	CreatedAt    time.Time   `db:"created_at"`
+}
+
+// fee_run_attempts table, one row per tx signed for a fee run
+type FeeRunAttempt struct {
+	ID         uuid.UUID         `db:"id"`
+	FeeRunID   uuid.UUID         `db:"fee_run_id"`
+	Kind       FeeRunAttemptKind `db:"kind"`
+	Nonce      uint64            `db:"nonce"`
+	TxHash     string            `db:"tx_hash"`
+	UnsignedTx string            `db:"unsigned_tx"` // base64
+	SignedTx   string            `db:"signed_tx"`   // base64, empty on attempts recorded before it was stored
+	Endpoint   string            `db:"endpoint"`    // host of the endpoint which accepted the tx, empty until it is broadcasted
+	CreatedAt  time.Time         `db:"created_at"`
 }
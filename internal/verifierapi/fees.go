@@ -2,12 +2,25 @@ package verifierapi
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/vultisig/verifier/types"
 )
 
+// ErrPublicKeyNotFound is returned when the verifier does not know the public key
+var ErrPublicKeyNotFound = errors.New("public key not found")
+
+// StatusError is returned when the verifier answers with an unexpected status code
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status code: %d", e.StatusCode)
+}
+
 func (v *VerifierApi) GetPublicKeysFees(ecdsaPublicKey string) ([]*types.Fee, error) {
 	response, err := v.getAuth(fmt.Sprintf("/fees/publickey/%s", ecdsaPublicKey))
 	if err != nil {
@@ -19,11 +32,11 @@ func (v *VerifierApi) GetPublicKeysFees(ecdsaPublicKey string) ([]*types.Fee, er
 		}
 	}()
 	if response.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("public key not found")
+		return nil, ErrPublicKeyNotFound
 	}
 
 	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get public key fees, status code: %d", response.StatusCode)
+		return nil, fmt.Errorf("failed to get public key fees: %w", &StatusError{StatusCode: response.StatusCode})
 	}
 
 	var feeHistory APIResponse[[]*types.Fee]
@@ -60,7 +73,7 @@ func (v *VerifierApi) MarkFeeAsCollected(amount uint64, txHash, network string,
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to mark fee as collected, status code: %d", response.StatusCode)
+		return fmt.Errorf("failed to mark fee as collected: %w", &StatusError{StatusCode: response.StatusCode})
 	}
 
 	return nil